@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -145,7 +146,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, tea.Quit
 
 		case "enter":
-			if m.input != "" && !m.isThinking {
+			if m.input != "" && m.canAcceptInput() {
 				// Add user message
 				m.lastMsgID++
 				userMsg := Message{
@@ -165,7 +166,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 
 		case "backspace":
-			if m.cursor > 0 {
+			if m.canAcceptInput() && m.cursor > 0 {
 				m.input = m.input[:m.cursor-1] + m.input[m.cursor:]
 				m.cursor--
 			}
@@ -192,7 +193,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 
 		default:
-			if !m.isThinking {
+			if m.canAcceptInput() {
 				m.input = m.input[:m.cursor] + msg.String() + m.input[m.cursor:]
 				m.cursor++
 			}
@@ -345,15 +346,36 @@ func (m Model) renderStatus() string {
 	return left + strings.Repeat(" ", gap) + right
 }
 
+// canAcceptInput reports whether the input box may take keys or send,
+// i.e. no response is pending.
+func (m Model) canAcceptInput() bool {
+	return !m.isThinking
+}
+
 func wordWrap(text string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+
 	var lines []string
 	words := strings.Fields(text)
 
 	var currentLine string
 	for _, word := range words {
+		// Break words longer than a whole line into width-sized chunks
+		for utf8.RuneCountInString(word) > width {
+			if currentLine != "" {
+				lines = append(lines, currentLine)
+				currentLine = ""
+			}
+			runes := []rune(word)
+			lines = append(lines, string(runes[:width]))
+			word = string(runes[width:])
+		}
+
 		if currentLine == "" {
 			currentLine = word
-		} else if len(currentLine)+1+len(word) <= width {
+		} else if utf8.RuneCountInString(currentLine)+1+utf8.RuneCountInString(word) <= width {
 			currentLine += " " + word
 		} else {
 			lines = append(lines, currentLine)
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func press(m Model, key tea.KeyMsg) Model {
+	next, _ := m.Update(key)
+	return next.(Model)
+}
+
+func TestWordWrapBreaksLongWords(t *testing.T) {
+	got := wordWrap("see "+strings.Repeat("x", 25)+" ok", 10)
+	want := []string{"see", "xxxxxxxxxx", "xxxxxxxxxx", "xxxxx ok"}
+	if !slices.Equal(got, want) {
+		t.Errorf("wordWrap = %q, want %q", got, want)
+	}
+	if got := wordWrap("日本語テキスト", 3); !slices.Equal(got, []string{"日本語", "テキス", "ト"}) {
+		t.Errorf("wordWrap split runes as %q", got)
+	}
+}
+
+func TestCanAcceptInput(t *testing.T) {
+	tests := []struct {
+		name     string
+		thinking bool
+		want     bool
+	}{
+		{"idle", false, true},
+		{"thinking", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := initialModel()
+			m.input, m.cursor = "hi", 2
+			m.isThinking = tt.thinking
+			if got := m.canAcceptInput(); got != tt.want {
+				t.Fatalf("canAcceptInput() = %v, want %v", got, tt.want)
+			}
+
+			typed := press(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("!")})
+			if got := typed.input == "hi!"; got != tt.want {
+				t.Errorf("typing: input %q", typed.input)
+			}
+			erased := press(m, tea.KeyMsg{Type: tea.KeyBackspace})
+			if got := erased.input == "h"; got != tt.want {
+				t.Errorf("backspace: input %q", erased.input)
+			}
+			sent := press(m, tea.KeyMsg{Type: tea.KeyEnter})
+			if got := len(sent.messages) == len(m.messages)+1; got != tt.want {
+				t.Errorf("enter: %d messages, had %d", len(sent.messages), len(m.messages))
+			}
+		})
+	}
+}
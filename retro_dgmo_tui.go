@@ -1,13 +1,36 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/dgmstt/shared/errorutil"
+	"github.com/dgmstt/shared/syncutil"
+	"github.com/muesli/termenv"
+	"github.com/rivo/uniseg"
 )
 
 // ============================================================================
@@ -21,787 +44,7054 @@ var (
 	crtBlue    = lipgloss.Color("#00D9FF") // Cyan blue
 	crtPink    = lipgloss.Color("#FF006E") // Hot pink
 	crtPurple  = lipgloss.Color("#8B00FF") // Purple
+	crtRed     = lipgloss.Color("#FF3131") // Alarm red
 	darkBg     = lipgloss.Color("#0A0A0A") // Almost black
 	darkGray   = lipgloss.Color("#1A1A1A") // Dark gray
 	mediumGray = lipgloss.Color("#333333") // Medium gray
 
-	// Retro Styles
-	borderStyle = lipgloss.NewStyle().
-			BorderStyle(lipgloss.DoubleBorder()).
-			BorderForeground(crtGreen)
-
-	titleBarStyle = lipgloss.NewStyle().
-			Background(crtGreen).
-			Foreground(darkBg).
-			Bold(true).
-			Padding(0, 2)
-
-	statusBarStyle = lipgloss.NewStyle().
-			Background(mediumGray).
-			Foreground(crtGreen).
-			Padding(0, 1)
-
-	messageBoxStyle = lipgloss.NewStyle().
-			BorderStyle(lipgloss.RoundedBorder()).
-			BorderForeground(crtBlue).
-			Padding(1).
-			MarginBottom(1)
-
-	userMsgStyle = messageBoxStyle.Copy().
-			BorderForeground(crtPink).
-			Foreground(crtPink)
-
-	aiMsgStyle = messageBoxStyle.Copy().
-			BorderForeground(crtBlue).
-			Foreground(crtBlue)
-
-	editorStyle = lipgloss.NewStyle().
-			BorderStyle(lipgloss.ThickBorder()).
-			BorderForeground(crtAmber).
-			Padding(1)
-
-	mcpPanelStyle = lipgloss.NewStyle().
-			BorderStyle(lipgloss.RoundedBorder()).
-			BorderForeground(crtPurple).
-			Padding(1)
-
-	toastStyle = lipgloss.NewStyle().
-			Background(crtGreen).
-			Foreground(darkBg).
-			Padding(0, 2).
-			MarginTop(1)
+	helpLines = []string{
+		"TAB        Switch panes",
+		"CTRL+M     Toggle MCP panel",
+		"CTRL+O     Toggle command output pane",
+		"CTRL+K     Command palette (ALT+X in emacs mode)",
+		"CTRL+G     Glitch effect",
+		"CTRL+R     Find and replace in input",
+		"CTRL+X     Interrupt a streaming response",
+		"CTRL+T     Transpose characters",
+		"CTRL+U     Kill to start of input",
+		"CTRL+Y     Yank last kill",
+		"CTRL+K     Kill to end of input (emacs mode)",
+		"PGUP/PGDN  Page messages",
+		"ALT+↑/↓    Select message",
+		"SHIFT+↑/↓  Extend message selection",
+		"C          Copy selected messages",
+		"Y          Copy code block of selected message",
+		"R          Retry selected failed message",
+		"ENTER/SPC  Expand or collapse selected message",
+		"A          Cycle reaction on selected message",
+		"F          Filter MCP ops by status",
+		"CTRL+D     Dismiss oldest toast",
+		"ALT+D      Clear all toasts",
+		"ALT+I      Toggle message IDs",
+		"SHIFT+TAB  Toggle alt screen",
+		"ESC        Close modal / palette",
+		"CTRL+C     Exit",
+	}
 
 	glitchChars = []string{"▓", "▒", "░", "█", "▄", "▀", "■", "□", "▪", "▫"}
 )
 
-// ============================================================================
-// Data Structures
-// ============================================================================
-
-type Message struct {
-	ID        int
-	Content   string
-	Role      string
-	Timestamp time.Time
-	Tool      string // For MCP operations
+// styles is a theme's palette adapted to one color profile, and the Retro
+// Styles built from it. Each Model renders with its own, so the package
+// palette never changes.
+type styles struct {
+	green, amber, blue, pink, purple, red lipgloss.Color
+	darkBg, darkGray, mediumGray          lipgloss.Color
+
+	border, titleBar, statusBar                lipgloss.Style
+	messageBox, userMsg, aiMsg                 lipgloss.Style
+	editor, mcpPanel                           lipgloss.Style
+	toast, successToast, errorToast, infoToast lipgloss.Style
+	muted                                      lipgloss.Style
 }
 
-type MCPOperation struct {
-	ID       string
-	Tool     string
-	Status   string
-	Progress int
-}
+// newStyles adapts the theme's palette to profile and builds the styles
+// from it.
+func newStyles(t Theme, profile termenv.Profile) *styles {
+	s := &styles{
+		green:      adaptColor(t.Primary, profile),
+		amber:      adaptColor(t.Accent, profile),
+		blue:       adaptColor(t.Info, profile),
+		pink:       adaptColor(t.User, profile),
+		purple:     adaptColor(t.Tool, profile),
+		red:        adaptColor(t.Error, profile),
+		darkBg:     adaptColor(t.Background, profile),
+		darkGray:   adaptColor(darkGray, profile),
+		mediumGray: adaptColor(t.Muted, profile),
+	}
 
-type Toast struct {
-	Message   string
-	Type      string
-	ExpiresAt time.Time
-}
+	s.border = lipgloss.NewStyle().
+		BorderStyle(themeBorder(t.Borders.Pane, lipgloss.DoubleBorder())).
+		BorderForeground(s.green)
 
-type Model struct {
-	// Layout
-	width, height int
+	s.titleBar = lipgloss.NewStyle().
+		Background(s.green).
+		Foreground(s.darkBg).
+		Bold(true).
+		Padding(0, 2)
 
-	// Content
-	messages []Message
-	input    string
-	cursor   int
+	s.statusBar = lipgloss.NewStyle().
+		Background(s.mediumGray).
+		Foreground(s.green).
+		Padding(0, 1)
 
-	// UI State
-	activePane   string // "messages", "editor", "mcp"
-	scrollOffset int
-	showMCP      bool
-	showCommand  bool
-	commandInput string
+	s.messageBox = lipgloss.NewStyle().
+		BorderStyle(themeBorder(t.Borders.Message, lipgloss.RoundedBorder())).
+		BorderForeground(s.blue).
+		Padding(1).
+		MarginBottom(1)
 
-	// Effects
-	glitchEffect bool
-	scanlineY    int
-	toasts       []Toast
+	s.userMsg = s.messageBox.Copy().
+		BorderForeground(s.pink).
+		Foreground(s.pink)
 
-	// MCP Operations
-	mcpOps       []MCPOperation
-	isProcessing bool
+	s.aiMsg = s.messageBox.Copy().
+		BorderForeground(s.blue).
+		Foreground(s.blue)
 
-	// Session Info
-	sessionID     string
-	contextTokens int
-	cost          float64
-}
+	s.editor = lipgloss.NewStyle().
+		BorderStyle(themeBorder(t.Borders.Editor, lipgloss.ThickBorder())).
+		BorderForeground(s.amber).
+		Padding(1)
 
-// ============================================================================
-// Messages
-// ============================================================================
+	s.mcpPanel = lipgloss.NewStyle().
+		BorderStyle(themeBorder(t.Borders.Panel, lipgloss.RoundedBorder())).
+		BorderForeground(s.purple).
+		Padding(1)
 
-type TickMsg time.Time
-type ProcessingDoneMsg struct {
-	response string
-	tool     string
-}
-type GlitchMsg struct{}
-type ScanlineMsg struct{}
+	s.toast = lipgloss.NewStyle().
+		Background(s.green).
+		Foreground(s.darkBg).
+		Padding(0, 2)
 
-// ============================================================================
-// Commands
-// ============================================================================
+	s.successToast = s.toast.Copy().Background(s.green)
+	s.errorToast = s.toast.Copy().Background(s.red)
+	s.infoToast = s.toast.Copy().Background(s.blue)
 
-func tickCmd() tea.Cmd {
-	return tea.Tick(time.Millisecond*100, func(t time.Time) tea.Msg {
-		return TickMsg(t)
-	})
+	s.muted = lipgloss.NewStyle().Foreground(s.mediumGray)
+	return s
 }
 
-func glitchCmd() tea.Cmd {
-	return tea.Tick(time.Millisecond*50, func(t time.Time) tea.Msg {
-		return GlitchMsg{}
-	})
+// role returns the color filling the named Theme role, or the primary
+// color for an unknown role.
+func (s *styles) role(name string) lipgloss.Color {
+	switch name {
+	case "accent":
+		return s.amber
+	case "info":
+		return s.blue
+	case "user":
+		return s.pink
+	case "tool":
+		return s.purple
+	case "error":
+		return s.red
+	}
+	return s.green
 }
 
-func scanlineCmd() tea.Cmd {
-	return tea.Tick(time.Millisecond*30, func(t time.Time) tea.Msg {
-		return ScanlineMsg{}
-	})
-}
+// detectColorProfile picks a color profile from $COLORTERM and $TERM.
+func detectColorProfile(getenv func(string) string) termenv.Profile {
+	switch strings.ToLower(getenv("COLORTERM")) {
+	case "truecolor", "24bit":
+		return termenv.TrueColor
+	}
 
-func processCommand(input string) tea.Cmd {
-	return func() tea.Msg {
-		time.Sleep(time.Millisecond * 1500)
+	term := getenv("TERM")
+	switch {
+	case term == "dumb":
+		return termenv.Ascii
+	case strings.Contains(term, "256color"):
+		return termenv.ANSI256
+	case term != "":
+		return termenv.ANSI
+	}
+	return termenv.TrueColor
+}
 
-		// Simulate different tools
-		tools := []string{"file_reader", "code_analyzer", "web_search", "calculator"}
-		tool := tools[rand.Intn(len(tools))]
+// parseColorProfile maps a configured profile name to a profile.
+func parseColorProfile(name string) (termenv.Profile, bool) {
+	switch strings.ToLower(name) {
+	case "truecolor":
+		return termenv.TrueColor, true
+	case "ansi256":
+		return termenv.ANSI256, true
+	case "ansi":
+		return termenv.ANSI, true
+	case "ascii":
+		return termenv.Ascii, true
+	}
+	return termenv.TrueColor, false
+}
 
-		response := generateResponse(input, tool)
-		return ProcessingDoneMsg{response: response, tool: tool}
+// envColorProfile is the profile to render with: the one named by
+// $RETRO_DGMO_COLOR_PROFILE, or else the one the terminal supports.
+func envColorProfile(getenv func(string) string) termenv.Profile {
+	if forced, ok := parseColorProfile(getenv("RETRO_DGMO_COLOR_PROFILE")); ok {
+		return forced
 	}
+	return detectColorProfile(getenv)
 }
 
-// ============================================================================
-// Model Implementation
-// ============================================================================
+// adaptColor maps a hex color to the nearest color the profile supports.
+// Colors that aren't hex are returned unchanged.
+func adaptColor(c lipgloss.Color, profile termenv.Profile) lipgloss.Color {
+	if !strings.HasPrefix(string(c), "#") {
+		return c
+	}
 
-func initialModel() Model {
-	return Model{
-		messages: []Message{
-			{
-				ID:        1,
-				Content:   "SYSTEM INITIALIZED. RETRO-DGMO v2.0 ONLINE.",
-				Role:      "system",
-				Timestamp: time.Now(),
-			},
-			{
-				ID:        2,
-				Content:   "Welcome to the retro-futuristic terminal. How may I assist you today?",
-				Role:      "assistant",
-				Timestamp: time.Now(),
-			},
-		},
-		activePane:    "editor",
-		sessionID:     fmt.Sprintf("RETRO-%d", time.Now().Unix()),
-		contextTokens: 1337,
-		cost:          0.42,
-		showMCP:       true,
-		mcpOps: []MCPOperation{
-			{ID: "OP-001", Tool: "system_check", Status: "completed", Progress: 100},
-		},
+	switch converted := profile.Convert(termenv.RGBColor(c)).(type) {
+	case termenv.ANSI256Color:
+		return lipgloss.Color(strconv.Itoa(int(converted)))
+	case termenv.ANSIColor:
+		return lipgloss.Color(strconv.Itoa(int(converted)))
+	case termenv.NoColor:
+		return lipgloss.Color("")
 	}
+	return c
 }
 
-func (m Model) Init() tea.Cmd {
-	return tea.Batch(
-		tea.EnterAltScreen,
-		tickCmd(),
-		scanlineCmd(),
-	)
+// Theme is a named CRT palette. Each color fills one role in the layout.
+// Themes can be loaded from JSON files with "theme load".
+type Theme struct {
+	Name       string         `json:"name"`
+	Primary    lipgloss.Color `json:"primary"` // borders, title bar, system text
+	Accent     lipgloss.Color `json:"accent"`  // editor and focus highlights
+	Info       lipgloss.Color `json:"info"`    // assistant messages
+	User       lipgloss.Color `json:"user"`    // user messages
+	Tool       lipgloss.Color `json:"tool"`    // MCP panel
+	Error      lipgloss.Color `json:"error"`   // failures
+	Background lipgloss.Color `json:"background"`
+	Muted      lipgloss.Color `json:"muted"`
+	Borders    ThemeBorders   `json:"borders"`
 }
 
-func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+c", "ctrl+q":
-			return m, tea.Quit
+// ThemeBorders names the border drawn around each kind of box, one of
+// borderStyles. Empty names keep the default.
+type ThemeBorders struct {
+	Pane    string `json:"pane,omitempty"`    // messages pane; double by default
+	Message string `json:"message,omitempty"` // message boxes; rounded
+	Editor  string `json:"editor,omitempty"`  // editor; thick
+	Panel   string `json:"panel,omitempty"`   // MCP panel; rounded
+}
 
-		case "tab":
-			// Cycle through panes
-			switch m.activePane {
-			case "messages":
-				m.activePane = "editor"
-			case "editor":
-				if m.showMCP {
-					m.activePane = "mcp"
-				} else {
-					m.activePane = "messages"
-				}
-			case "mcp":
-				m.activePane = "messages"
-			}
+// borderStyles are the border names a theme may use.
+var borderStyles = map[string]func() lipgloss.Border{
+	"normal":  lipgloss.NormalBorder,
+	"rounded": lipgloss.RoundedBorder,
+	"double":  lipgloss.DoubleBorder,
+	"thick":   lipgloss.ThickBorder,
+	"block":   lipgloss.BlockBorder,
+	"ascii":   lipgloss.ASCIIBorder,
+	"hidden":  lipgloss.HiddenBorder,
+}
 
-		case "ctrl+m":
-			m.showMCP = !m.showMCP
-			if !m.showMCP && m.activePane == "mcp" {
-				m.activePane = "editor"
-			}
-			toast := "MCP PANEL: ACTIVATED"
-			if !m.showMCP {
-				toast = "MCP PANEL: DEACTIVATED"
-			}
-			m.addToast(toast, "info")
+// themeBorder returns the named border, or fallback for an empty name.
+func themeBorder(name string, fallback lipgloss.Border) lipgloss.Border {
+	if border, ok := borderStyles[name]; ok {
+		return border()
+	}
+	return fallback
+}
 
-		case "ctrl+k":
-			m.showCommand = !m.showCommand
-			if m.showCommand {
-				m.commandInput = ""
-			}
+// hexColorPattern matches the "#RGB" and "#RRGGBB" colors themes use.
+var hexColorPattern = regexp.MustCompile(`^#([0-9A-Fa-f]{3}|[0-9A-Fa-f]{6})$`)
 
-		case "ctrl+g":
-			// Toggle glitch effect
-			m.glitchEffect = !m.glitchEffect
-			if m.glitchEffect {
-				return m, glitchCmd()
-			}
+// validateTheme checks that every color of t is a hex color and every
+// border a known style.
+func validateTheme(t Theme) error {
+	if t.Name == "" {
+		return fmt.Errorf("theme has no name")
+	}
+	colors := []struct {
+		role  string
+		color lipgloss.Color
+	}{
+		{"primary", t.Primary}, {"accent", t.Accent}, {"info", t.Info}, {"user", t.User},
+		{"tool", t.Tool}, {"error", t.Error}, {"background", t.Background}, {"muted", t.Muted},
+	}
+	for _, c := range colors {
+		if !hexColorPattern.MatchString(string(c.color)) {
+			return fmt.Errorf("%s color %q is not #RGB or #RRGGBB", c.role, c.color)
+		}
+	}
+	for _, name := range []string{t.Borders.Pane, t.Borders.Message, t.Borders.Editor, t.Borders.Panel} {
+		if _, ok := borderStyles[name]; name != "" && !ok {
+			return fmt.Errorf("unknown border %q", name)
+		}
+	}
+	return nil
+}
 
-		case "enter":
-			if m.showCommand {
-				// Execute command
-				m.executeCommand()
-				m.showCommand = false
-			} else if m.activePane == "editor" && m.input != "" && !m.isProcessing {
-				// Send message
-				m.messages = append(m.messages, Message{
-					ID:        len(m.messages) + 1,
-					Content:   m.input,
-					Role:      "user",
-					Timestamp: time.Now(),
-				})
+// loadTheme reads and validates a theme saved as JSON. A theme without a
+// name is named after the file.
+func loadTheme(path string) (Theme, error) {
+	var t Theme
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return t, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&t); err != nil {
+		return t, fmt.Errorf("parse %s: %w", filepath.Base(path), err)
+	}
+	if t.Name == "" {
+		t.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return t, validateTheme(t)
+}
 
-				// Add MCP operation
-				m.mcpOps = append(m.mcpOps, MCPOperation{
-					ID:       fmt.Sprintf("OP-%03d", len(m.mcpOps)+1),
-					Tool:     "processing",
-					Status:   "running",
-					Progress: 0,
-				})
+// saveTheme writes t to path as JSON that loadTheme reads back.
+func saveTheme(path string, t Theme) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
 
-				m.isProcessing = true
-				cmd := processCommand(m.input)
-				m.input = ""
-				m.cursor = 0
-				m.contextTokens += rand.Intn(100) + 50
-				m.cost += float64(rand.Intn(10)) / 100
+// themes are the built-in palettes selectable by name.
+var themes = map[string]Theme{
+	"classic": {
+		Name:       "classic",
+		Primary:    crtGreen,
+		Accent:     crtAmber,
+		Info:       crtBlue,
+		User:       crtPink,
+		Tool:       crtPurple,
+		Error:      crtRed,
+		Background: darkBg,
+		Muted:      mediumGray,
+	},
+	"amber": {
+		Name:       "amber",
+		Primary:    "#FFB000",
+		Accent:     "#FFD27F",
+		Info:       "#FFC940",
+		User:       "#FF8C00",
+		Tool:       "#CC8400",
+		Error:      "#FF3131",
+		Background: "#0A0700",
+		Muted:      "#4D3500",
+	},
+	"phosphor": {
+		Name:       "phosphor",
+		Primary:    "#33FF33",
+		Accent:     "#B3FFB3",
+		Info:       "#66FF66",
+		User:       "#00CC00",
+		Tool:       "#009900",
+		Error:      "#FF3131",
+		Background: "#000A00",
+		Muted:      "#1F4D1F",
+	},
+}
 
-				return m, cmd
-			}
+// ============================================================================
+// Data Structures
+// ============================================================================
 
-		case "backspace":
-			if m.showCommand && len(m.commandInput) > 0 {
-				m.commandInput = m.commandInput[:len(m.commandInput)-1]
-			} else if m.activePane == "editor" && m.cursor > 0 {
-				m.input = m.input[:m.cursor-1] + m.input[m.cursor:]
-				m.cursor--
-			}
+type Message struct {
+	ID        int       `json:"id"`
+	Content   string    `json:"content"`
+	Role      string    `json:"role"`
+	Timestamp time.Time `json:"timestamp"`
+	Tool      string    `json:"tool,omitempty"`   // For MCP operations
+	Failed    bool      `json:"failed,omitempty"` // the response to this message failed
+	Error     string    `json:"error,omitempty"`
+	Collapsed bool      `json:"collapsed,omitempty"` // only the first lines are shown
+	Reaction  string    `json:"reaction,omitempty"`  // review annotation, one of reactions
+	Pinned    bool      `json:"pinned,omitempty"`    // marked with pin <id>
+}
 
-		case "left":
-			if m.activePane == "editor" && m.cursor > 0 {
-				m.cursor--
-			}
+// toolBadge is how a message produced by a tool is marked in the messages
+// pane: a colored icon and label inline, and a one-line detail shown while
+// the message is selected.
+type toolBadge struct {
+	icon   string
+	label  string
+	color  string // theme role, so the badge follows the theme; see styles.role
+	detail string
+}
 
-		case "right":
-			if m.activePane == "editor" && m.cursor < len(m.input) {
-				m.cursor++
-			}
+// toolBadges is the registry of known tools; others fall back to the plain
+// AI[tool]> prefix.
+var toolBadges = map[string]toolBadge{
+	"file_reader":   {"▤", "FILE", "info", "read files from the workspace"},
+	"code_analyzer": {"◈", "CODE", "tool", "analyzed the code in the prompt"},
+	"web_search":    {"◎", "WEB", "accent", "searched the web"},
+	"calculator":    {"∑", "CALC", "primary", "evaluated an expression"},
+	"summary":       {"≡", "SUM", "user", "summarized the conversation"},
+	"tail":          {"↻", "TAIL", "accent", "line appended to a tailed file"},
+}
 
-		case "up":
-			if m.activePane == "messages" && m.scrollOffset > 0 {
-				m.scrollOffset--
-			}
+// text is the badge as it appears before styling.
+func (b toolBadge) text() string {
+	return " " + b.icon + " " + b.label + " "
+}
 
-		case "down":
-			if m.activePane == "messages" {
-				m.scrollOffset++
-			}
+// render draws the badge as a chip in its color from s.
+func (b toolBadge) render(s *styles) string {
+	return lipgloss.NewStyle().Background(s.role(b.color)).Foreground(s.darkBg).Bold(true).Render(b.text())
+}
 
-		default:
-			if m.showCommand {
-				m.commandInput += msg.String()
-			} else if m.activePane == "editor" && !m.isProcessing {
-				m.input = m.input[:m.cursor] + msg.String() + m.input[m.cursor:]
-				m.cursor++
+// reactions are the annotations the "a" key cycles a message through.
+var reactions = []string{"⭐", "❓", "✅"}
+
+// nextReaction returns the reaction after current, or "" after the last.
+func nextReaction(current string) string {
+	for i, r := range reactions {
+		if r == current {
+			if i+1 < len(reactions) {
+				return reactions[i+1]
 			}
+			return ""
 		}
+	}
+	return reactions[0]
+}
 
-	case tea.WindowSizeMsg:
-		m.width = msg.Width
-		m.height = msg.Height
+const (
+	collapseThreshold = 12 // wrapped lines in the messages pane before collapsing
+	collapsedLines    = 3  // lines shown while collapsed
+)
 
-	case TickMsg:
-		// Update MCP operations
-		for i := range m.mcpOps {
-			if m.mcpOps[i].Status == "running" {
-				m.mcpOps[i].Progress += 10
-				if m.mcpOps[i].Progress >= 100 {
-					m.mcpOps[i].Progress = 100
-					m.mcpOps[i].Status = "completed"
-				}
-			}
-		}
+// ResponseProvider produces the assistant's reply to a user message.
+type ResponseProvider interface {
+	Respond(ctx context.Context, input string) (Response, error)
+}
 
-		// Clean expired toasts
-		var activeToasts []Toast
-		now := time.Now()
-		for _, toast := range m.toasts {
-			if toast.ExpiresAt.After(now) {
-				activeToasts = append(activeToasts, toast)
-			}
-		}
-		m.toasts = activeToasts
+// Response is a provider's reply and the tool it used, if any.
+type Response struct {
+	Content string
+	Tool    string
+}
 
-		return m, tickCmd()
+// safeRespond calls the provider, turning a panic into an error so a bad
+// provider can't take down the UI.
+func safeRespond(ctx context.Context, provider ResponseProvider, input string) (resp Response, err error) {
+	defer errorutil.PanicHandler(&err)
+	return provider.Respond(ctx, input)
+}
 
-	case ProcessingDoneMsg:
-		m.isProcessing = false
+// HealthChecker is implemented by providers that can report whether they
+// are reachable without producing a reply.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
 
-		// Update MCP operation
-		if len(m.mcpOps) > 0 {
-			m.mcpOps[len(m.mcpOps)-1].Status = "completed"
-			m.mcpOps[len(m.mcpOps)-1].Tool = msg.tool
-		}
+// pingTimeout bounds a provider health check.
+const pingTimeout = 5 * time.Second
 
-		// Add response
-		m.messages = append(m.messages, Message{
-			ID:        len(m.messages) + 1,
-			Content:   msg.response,
-			Role:      "assistant",
-			Timestamp: time.Now(),
-			Tool:      msg.tool,
+// pingCmd runs the provider's health check in the background and reports
+// how long it took.
+func pingCmd(checker HealthChecker) tea.Cmd {
+	return func() tea.Msg {
+		start := time.Now()
+		_, err := callWithin(context.Background(), pingTimeout, func(ctx context.Context) (resp Response, err error) {
+			defer errorutil.PanicHandler(&err)
+			return Response{}, checker.HealthCheck(ctx)
 		})
+		return PingDoneMsg{latency: time.Since(start), err: err}
+	}
+}
 
-		m.addToast("PROCESSING COMPLETE", "success")
+// providers holds the selectable response providers by lowercase name.
+var providers = map[string]ResponseProvider{
+	"canned": cannedProvider{delay: 1500 * time.Millisecond},
+	"echo":   echoProvider{},
+}
 
-	case GlitchMsg:
-		if m.glitchEffect {
-			return m, glitchCmd()
-		}
+// defaultModel names the provider used when none is selected.
+const defaultModel = "canned"
 
-	case ScanlineMsg:
-		m.scanlineY = (m.scanlineY + 1) % m.height
-		return m, scanlineCmd()
+// RegisterProvider makes provider selectable by name with the "model"
+// command and the -model flag. Like RegisterCommand it is meant to be
+// called at startup, and fails on an invalid or taken name.
+func RegisterProvider(name string, provider ResponseProvider) error {
+	name = strings.ToLower(name)
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("invalid provider name %q", name)
 	}
-
-	return m, nil
+	if provider == nil {
+		return fmt.Errorf("provider %q is nil", name)
+	}
+	if _, ok := providers[name]; ok {
+		return fmt.Errorf("provider %q is already registered", name)
+	}
+	providers[name] = provider
+	return nil
 }
 
-func (m Model) View() string {
-	if m.width == 0 || m.height == 0 {
-		return "INITIALIZING..."
+// providerNames lists the registered providers, sorted.
+func providerNames() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
+}
 
-	// Build layout
-	var content string
-
-	// Title bar
-	title := titleBarStyle.Width(m.width).Render("◼ RETRO-DGMO TERMINAL v2.0 ◼")
+// echoProvider answers immediately by repeating the input; it is handy
+// for exercising the UI without the canned provider's delay.
+type echoProvider struct{}
 
-	// Main content area
-	mainHeight := m.height - 4 // Title, status, margins
+func (echoProvider) Respond(ctx context.Context, input string) (Response, error) {
+	return Response{Content: "ECHO: " + input}, nil
+}
 
-	if m.showMCP {
-		// Three-column layout
-		messagesWidth := m.width * 4 / 10
-		editorWidth := m.width * 4 / 10
-		mcpWidth := m.width * 2 / 10
+func (echoProvider) HealthCheck(ctx context.Context) error {
+	return nil
+}
 
-		messages := m.renderMessages(messagesWidth, mainHeight)
-		editor := m.renderEditor(editorWidth, mainHeight)
-		mcp := m.renderMCP(mcpWidth, mainHeight)
+// cannedProvider is the built-in offline provider: it waits to simulate
+// latency and answers from generateResponse with a random tool.
+type cannedProvider struct {
+	delay time.Duration
+}
 
-		content = lipgloss.JoinHorizontal(lipgloss.Top, messages, editor, mcp)
-	} else {
-		// Two-column layout
-		messagesWidth := m.width / 2
-		editorWidth := m.width / 2
+func (p cannedProvider) Respond(ctx context.Context, input string) (Response, error) {
+	select {
+	case <-time.After(p.delay):
+	case <-ctx.Done():
+		return Response{}, ctx.Err()
+	}
 
-		messages := m.renderMessages(messagesWidth, mainHeight)
-		editor := m.renderEditor(editorWidth, mainHeight)
+	// Simulate different tools
+	tools := []string{"file_reader", "code_analyzer", "web_search", "calculator"}
+	tool := tools[rand.Intn(len(tools))]
 
-		content = lipgloss.JoinHorizontal(lipgloss.Top, messages, editor)
-	}
+	return Response{Content: generateResponse(input, tool), Tool: tool}, nil
+}
 
-	// Status bar
-	status := m.renderStatus()
+// HealthCheck always succeeds: the canned provider has nothing to reach.
+func (p cannedProvider) HealthCheck(ctx context.Context) error {
+	return nil
+}
 
-	// Command palette overlay
-	if m.showCommand {
-		content = m.renderCommandPalette(content)
+// Stream answers like Respond but delivers the reply a word at a time.
+func (p cannedProvider) Stream(ctx context.Context, input string, chunk func(string)) (Response, error) {
+	resp, err := p.Respond(ctx, input)
+	if err != nil {
+		return resp, err
 	}
 
-	// Toast overlay
-	if len(m.toasts) > 0 {
-		content = m.renderToasts(content)
+	words := strings.SplitAfter(resp.Content, " ")
+	for _, word := range words {
+		select {
+		case <-time.After(streamWordDelay):
+		case <-ctx.Done():
+			return Response{}, ctx.Err()
+		}
+		chunk(word)
 	}
+	return resp, nil
+}
 
-	// Apply CRT effects
-	final := lipgloss.JoinVertical(lipgloss.Left, title, content, status)
+// streamWordDelay paces the canned provider's streamed words.
+const streamWordDelay = 40 * time.Millisecond
 
-	if m.glitchEffect {
-		final = m.applyGlitch(final)
-	}
+// StreamingProvider is a ResponseProvider that can also deliver its reply
+// incrementally, calling chunk with each piece as it arrives. The returned
+// Response holds the whole reply.
+type StreamingProvider interface {
+	ResponseProvider
+	Stream(ctx context.Context, input string, chunk func(string)) (Response, error)
+}
 
-	return m.applyScanline(final)
+// safeStream calls provider.Stream, turning a panic into an error.
+func safeStream(ctx context.Context, provider StreamingProvider, input string, chunk func(string)) (resp Response, err error) {
+	defer errorutil.PanicHandler(&err)
+	return provider.Stream(ctx, input, chunk)
 }
 
-// ============================================================================
+type MCPOperation struct {
+	ID        string `json:"id"`
+	Tool      string `json:"tool"`
+	Status    string `json:"status"`
+	Progress  int    `json:"progress"`             // negative when the operation can't report progress
+	MessageID int    `json:"message_id,omitempty"` // the user message being answered, if any
+}
+
+// ProgressBar renders a horizontal bar with eighth-block precision so small
+// percentages still move the bar.
+type ProgressBar struct{}
+
+// partialBlocks holds the left-aligned eighth blocks, indexed by eighths.
+var partialBlocks = []string{"", "▏", "▎", "▍", "▌", "▋", "▊", "▉"}
+
+// Render draws the bar width cells wide, filled to percent (clamped 0–100).
+func (ProgressBar) Render(width, percent int, style lipgloss.Style) string {
+	if width <= 0 {
+		return ""
+	}
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+
+	eighths := width * 8 * percent / 100
+	full, partial := eighths/8, eighths%8
+
+	bar := strings.Repeat("█", full)
+	empty := width - full
+	if partial > 0 {
+		bar += partialBlocks[partial]
+		empty--
+	}
+	bar += strings.Repeat("░", empty)
+
+	return style.Render(bar)
+}
+
+// marqueeWidth is the length of the block that sweeps an indeterminate bar.
+const marqueeWidth = 3
+
+// RenderIndeterminate draws a marquee block at pos, wrapping around the end
+// of the bar, for operations that don't report a percentage.
+func (ProgressBar) RenderIndeterminate(width, pos int, style lipgloss.Style) string {
+	if width <= 0 {
+		return ""
+	}
+
+	cells := make([]string, width)
+	for i := range cells {
+		cells[i] = "░"
+	}
+	for i := 0; i < marqueeWidth && i < width; i++ {
+		cells[((pos+i)%width+width)%width] = "█"
+	}
+
+	return style.Render(strings.Join(cells, ""))
+}
+
+// cursorGlyphs maps the configurable editor cursor styles to their glyphs.
+var cursorGlyphs = map[string]string{
+	"block":     "▊",
+	"bar":       "│",
+	"underline": "_",
+}
+
+// cursorBlinkTicks is how many ticks the cursor stays on or off when blinking.
+const cursorBlinkTicks = 5
+
+// lineCacheWidths is how many pane widths keep their wrapped lines, so
+// resizing back and forth doesn't re-wrap the conversation.
+const lineCacheWidths = 3
+
+// lineCache memoizes the rendered lines of each message per pane width.
+// Messages only store their raw content; an entry is reused while the
+// message is unchanged, and the least recently used width is evicted once
+// more than lineCacheWidths are cached. A nil cache disables caching.
+type lineCache struct {
+	widths  []int                          // cached widths, most recent last
+	entries map[int]map[int]lineCacheEntry // by width, then message ID
+}
+
+type lineCacheEntry struct {
+	msg      Message
+	selected bool
+	lines    []string
+}
+
+func newLineCache() *lineCache {
+	return &lineCache{entries: make(map[int]map[int]lineCacheEntry)}
+}
+
+func (c *lineCache) get(width int, msg Message, selected bool) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+	bucket, ok := c.entries[width]
+	if !ok {
+		return nil, false
+	}
+	c.touch(width)
+
+	entry, ok := bucket[msg.ID]
+	if !ok || entry.msg != msg || entry.selected != selected {
+		return nil, false
+	}
+	return entry.lines, true
+}
+
+func (c *lineCache) put(width int, msg Message, selected bool, lines []string) {
+	if c == nil {
+		return
+	}
+	bucket, ok := c.entries[width]
+	if !ok {
+		bucket = make(map[int]lineCacheEntry)
+		c.entries[width] = bucket
+	}
+	c.touch(width)
+	bucket[msg.ID] = lineCacheEntry{msg: msg, selected: selected, lines: lines}
+}
+
+// touch marks width as most recently used, evicting the oldest width when
+// the cache is over capacity.
+func (c *lineCache) touch(width int) {
+	for i, w := range c.widths {
+		if w == width {
+			c.widths = append(c.widths[:i], c.widths[i+1:]...)
+			break
+		}
+	}
+	c.widths = append(c.widths, width)
+
+	for len(c.widths) > lineCacheWidths {
+		delete(c.entries, c.widths[0])
+		c.widths = c.widths[1:]
+	}
+}
+
+// invalidate drops every cached entry, e.g. after a style change.
+func (c *lineCache) invalidate() {
+	if c != nil {
+		c.widths = nil
+		c.entries = make(map[int]map[int]lineCacheEntry)
+	}
+}
+
+// maxOutputLines is how many lines the command output pane keeps.
+const maxOutputLines = 500
+
+// CommandOutput is the scrollback of the command output pane. Scroll is
+// counted up from the newest line, so new output stays in view unless the
+// user has scrolled back.
+type CommandOutput struct {
+	lines  []string
+	scroll int // lines scrolled back from the bottom
+}
+
+// Append adds text, one entry per line, dropping the oldest lines past
+// maxOutputLines.
+func (o *CommandOutput) Append(text string) {
+	o.lines = append(o.lines, strings.Split(text, "\n")...)
+	if len(o.lines) > maxOutputLines {
+		o.lines = o.lines[len(o.lines)-maxOutputLines:]
+	}
+}
+
+// Scroll moves the view back (positive delta) or forward through the
+// output, clamped so at least one line stays visible.
+func (o *CommandOutput) Scroll(delta int) {
+	o.scroll += delta
+	if max := len(o.lines) - 1; o.scroll > max {
+		o.scroll = max
+	}
+	if o.scroll < 0 {
+		o.scroll = 0
+	}
+}
+
+// Visible returns the lines shown in a pane with room for height lines.
+func (o CommandOutput) Visible(height int) []string {
+	if height <= 0 {
+		return nil
+	}
+	end := len(o.lines) - o.scroll
+	start := end - height
+	if start < 0 {
+		start = 0
+	}
+	return o.lines[start:end]
+}
+
+// latencyTracker measures response latency: the time from sending a
+// message to its ProcessingDoneMsg, averaged over the session.
+type latencyTracker struct {
+	pending map[int]time.Time // send time by message ID
+	total   time.Duration
+	count   int
+}
+
+// Start records that message id was sent at t.
+func (l *latencyTracker) Start(id int, t time.Time) {
+	if l.pending == nil {
+		l.pending = make(map[int]time.Time)
+	}
+	l.pending[id] = t
+}
+
+// Finish records the answer to message id arriving at t and returns its
+// latency. With ok false the message was never started. Failed responses
+// are finished with count false so they don't skew the average.
+func (l *latencyTracker) Finish(id int, t time.Time, count bool) (d time.Duration, ok bool) {
+	sent, ok := l.pending[id]
+	if !ok {
+		return 0, false
+	}
+	delete(l.pending, id)
+
+	d = t.Sub(sent)
+	if count {
+		l.total += d
+		l.count++
+	}
+	return d, true
+}
+
+// Average is the mean latency of the counted responses.
+func (l latencyTracker) Average() time.Duration {
+	if l.count == 0 {
+		return 0
+	}
+	return l.total / time.Duration(l.count)
+}
+
+// perfWindow is how many recent calls the perf averages cover.
+const perfWindow = 30
+
+// rollingAverage averages the last perfWindow durations added to it.
+type rollingAverage struct {
+	samples []time.Duration
+	next    int
+	sum     time.Duration
+	last    time.Duration
+}
+
+func (r *rollingAverage) Add(d time.Duration) {
+	r.last = d
+	if len(r.samples) < perfWindow {
+		r.samples = append(r.samples, d)
+	} else {
+		r.sum -= r.samples[r.next]
+		r.samples[r.next] = d
+		r.next = (r.next + 1) % perfWindow
+	}
+	r.sum += d
+}
+
+func (r *rollingAverage) Average() time.Duration {
+	if len(r.samples) == 0 {
+		return 0
+	}
+	return r.sum / time.Duration(len(r.samples))
+}
+
+// perfStats times Update and View. It is shared across model copies, and a
+// nil *perfStats records nothing.
+type perfStats struct {
+	update, view rollingAverage
+}
+
+func (p *perfStats) recordUpdate(start time.Time) {
+	if p != nil {
+		p.update.Add(time.Since(start))
+	}
+}
+
+func (p *perfStats) recordView(start time.Time) {
+	if p != nil {
+		p.view.Add(time.Since(start))
+	}
+}
+
+type Toast struct {
+	Message   string
+	Type      string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// maxNotifications is how many past toasts the notifications command keeps.
+const maxNotifications = 100
+
+// ToastConfig controls where toasts are drawn and how many stack up before
+// the rest are collapsed into a "+N more" line.
+type ToastConfig struct {
+	Position   string // "top-center", "top-right" or "bottom-right"
+	MaxVisible int    // 0 shows every active toast
+}
+
+// defaultMaxToasts is how many toasts show before the rest collapse into
+// a "+N more" line.
+const defaultMaxToasts = 3
+
+// toastPositions are the valid ToastConfig positions.
+var toastPositions = []string{"top-center", "top-right", "bottom-right"}
+
+// setToastPosition moves where toasts are drawn.
+func (m *Model) setToastPosition(position string) error {
+	position = strings.ToLower(position)
+	if !slices.Contains(toastPositions, position) {
+		return fmt.Errorf("toast position must be %s", strings.Join(toastPositions, ", "))
+	}
+	m.toastConfig.Position = position
+	return nil
+}
+
+func defaultToastConfig() ToastConfig {
+	return ToastConfig{Position: "top-center", MaxVisible: defaultMaxToasts}
+}
+
+// defaultToastDuration applies to toast types missing from toastDurations.
+const defaultToastDuration = 3 * time.Second
+
+func defaultToastDurations() map[string]time.Duration {
+	return map[string]time.Duration{
+		"info":    2 * time.Second,
+		"success": 3 * time.Second,
+		"error":   6 * time.Second,
+	}
+}
+
+// Modal is a centered overlay that takes keyboard focus while it is open.
+// Update returns done=true when the modal should be closed. View draws with
+// the model's styles.
+type Modal interface {
+	View(width, height int, s *styles) string
+	Update(msg tea.Msg) (Modal, tea.Cmd, bool)
+}
+
+// textModal is a scrollable, read-only modal for help and report screens.
+type textModal struct {
+	title  string
+	lines  []string
+	offset int
+}
+
+func (t textModal) Update(msg tea.Msg) (Modal, tea.Cmd, bool) {
+	if key, ok := msg.(tea.KeyMsg); ok {
+		switch key.String() {
+		case "up", "k":
+			if t.offset > 0 {
+				t.offset--
+			}
+		case "down", "j":
+			if t.offset < len(t.lines)-1 {
+				t.offset++
+			}
+		case "enter", "q":
+			return t, nil, true
+		}
+	}
+	return t, nil, false
+}
+
+func (t textModal) View(width, height int, s *styles) string {
+	visible := t.lines[t.offset:]
+	if max := height - 5; max > 0 && len(visible) > max {
+		visible = visible[:max]
+	}
+
+	body := lipgloss.JoinVertical(lipgloss.Left,
+		lipgloss.NewStyle().Bold(true).Render(t.title),
+		"",
+		strings.Join(visible, "\n"),
+	)
+
+	return lipgloss.NewStyle().
+		BorderStyle(lipgloss.DoubleBorder()).
+		BorderForeground(s.green).
+		Background(s.darkBg).
+		Foreground(s.green).
+		Padding(0, 1).
+		Width(width - 4).
+		Render(body)
+}
+
+// confirmModal asks a yes/no question and emits a ConfirmMsg for the action
+// when the user answers yes.
+type confirmModal struct {
+	prompt string
+	action string
+}
+
+func (c confirmModal) Update(msg tea.Msg) (Modal, tea.Cmd, bool) {
+	if key, ok := msg.(tea.KeyMsg); ok {
+		switch key.String() {
+		case "y", "enter":
+			action := c.action
+			return c, func() tea.Msg { return ConfirmMsg{Action: action} }, true
+		case "n":
+			return c, nil, true
+		}
+	}
+	return c, nil, false
+}
+
+func (c confirmModal) View(width, height int, s *styles) string {
+	return lipgloss.NewStyle().
+		BorderStyle(lipgloss.DoubleBorder()).
+		BorderForeground(s.amber).
+		Background(s.darkBg).
+		Foreground(s.amber).
+		Padding(1, 2).
+		Render(c.prompt + "\n\n[Y]ES / [N]O")
+}
+
+// replaceModal prompts for a find and a replace string and emits a
+// ReplaceMsg with both once the replace field is submitted.
+type replaceModal struct {
+	find, replace string
+	field         int // 0 edits find, 1 edits replace
+}
+
+func (r replaceModal) Update(msg tea.Msg) (Modal, tea.Cmd, bool) {
+	key, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return r, nil, false
+	}
+
+	text := &r.find
+	if r.field == 1 {
+		text = &r.replace
+	}
+
+	switch key.String() {
+	case "tab":
+		r.field = 1 - r.field
+	case "enter":
+		if r.field == 0 {
+			r.field = 1
+			break
+		}
+		find, replace := r.find, r.replace
+		return r, func() tea.Msg { return ReplaceMsg{Find: find, Replace: replace} }, true
+	case "backspace":
+		if runes := []rune(*text); len(runes) > 0 {
+			*text = string(runes[:len(runes)-1])
+		}
+	default:
+		if isPrintableKey(key) {
+			*text += string(key.Runes)
+		}
+	}
+	return r, nil, false
+}
+
+func (r replaceModal) View(width, height int, s *styles) string {
+	fields := []string{"FIND:    " + r.find, "REPLACE: " + r.replace}
+	fields[r.field] += "█"
+
+	return lipgloss.NewStyle().
+		BorderStyle(lipgloss.DoubleBorder()).
+		BorderForeground(s.pink).
+		Background(s.darkBg).
+		Foreground(s.pink).
+		Padding(1, 2).
+		Render("REPLACE IN INPUT\n\n" + strings.Join(fields, "\n") +
+			"\n\n[TAB] SWITCH  [ENTER] APPLY")
+}
+
+// notesModal edits the session scratchpad. Enter starts a new line and
+// ctrl+s emits a NotesMsg with the text; esc discards the edit.
+type notesModal struct {
+	text string
+}
+
+func (n notesModal) Update(msg tea.Msg) (Modal, tea.Cmd, bool) {
+	key, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return n, nil, false
+	}
+
+	switch key.String() {
+	case "ctrl+s":
+		text := n.text
+		return n, func() tea.Msg { return NotesMsg{Text: text} }, true
+	case "enter":
+		n.text += "\n"
+	case "backspace":
+		runes := []rune(n.text)
+		n.text = string(runes[:prevGrapheme(n.text, len(runes))])
+	default:
+		if isPrintableKey(key) {
+			n.text += string(key.Runes)
+		}
+	}
+	return n, nil, false
+}
+
+func (n notesModal) View(width, height int, s *styles) string {
+	lines := strings.Split(n.text+"█", "\n")
+	if max := height - 10; max > 0 && len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+
+	return lipgloss.NewStyle().
+		BorderStyle(lipgloss.DoubleBorder()).
+		BorderForeground(s.amber).
+		Background(s.darkBg).
+		Foreground(s.amber).
+		Padding(1, 2).
+		Width(width - 4).
+		Render("SCRATCHPAD\n\n" + strings.Join(lines, "\n") +
+			"\n\n[CTRL+S] SAVE  [ESC] DISCARD")
+}
+
+// listModal offers a list of choices; enter emits a ChooseMsg for its
+// action with the highlighted one.
+type listModal struct {
+	title   string
+	action  string
+	choices []string
+	cursor  int
+}
+
+func (l listModal) Update(msg tea.Msg) (Modal, tea.Cmd, bool) {
+	if key, ok := msg.(tea.KeyMsg); ok {
+		switch key.String() {
+		case "up", "k":
+			if l.cursor > 0 {
+				l.cursor--
+			}
+		case "down", "j":
+			if l.cursor < len(l.choices)-1 {
+				l.cursor++
+			}
+		case "enter":
+			if len(l.choices) == 0 {
+				return l, nil, true
+			}
+			choice := ChooseMsg{Action: l.action, Choice: l.choices[l.cursor]}
+			return l, func() tea.Msg { return choice }, true
+		case "q":
+			return l, nil, true
+		}
+	}
+	return l, nil, false
+}
+
+func (l listModal) View(width, height int, s *styles) string {
+	lines := []string{l.title, ""}
+	for i, choice := range l.choices {
+		if i == l.cursor {
+			choice = lipgloss.NewStyle().Foreground(s.darkBg).Background(s.green).Render(choice)
+		}
+		lines = append(lines, choice)
+	}
+	lines = append(lines, "", "[ENTER] SELECT  [ESC] CLOSE")
+
+	return lipgloss.NewStyle().
+		BorderStyle(lipgloss.DoubleBorder()).
+		BorderForeground(s.green).
+		Background(s.darkBg).
+		Foreground(s.green).
+		Padding(0, 1).
+		Render(strings.Join(lines, "\n"))
+}
+
+// grepModal lists session search results; enter opens the session of the
+// highlighted match with an OpenSessionMsg.
+type grepModal struct {
+	pattern string
+	matches []sessionMatch
+	cursor  int
+}
+
+func (g grepModal) Update(msg tea.Msg) (Modal, tea.Cmd, bool) {
+	if key, ok := msg.(tea.KeyMsg); ok {
+		switch key.String() {
+		case "up", "k":
+			if g.cursor > 0 {
+				g.cursor--
+			}
+		case "down", "j":
+			if g.cursor < len(g.matches)-1 {
+				g.cursor++
+			}
+		case "enter":
+			if len(g.matches) == 0 {
+				return g, nil, true
+			}
+			path := g.matches[g.cursor].Path
+			return g, func() tea.Msg { return OpenSessionMsg{Path: path} }, true
+		case "q":
+			return g, nil, true
+		}
+	}
+	return g, nil, false
+}
+
+func (g grepModal) View(width, height int, s *styles) string {
+	lines := []string{fmt.Sprintf("GREP %q: %d MATCH(ES)", g.pattern, len(g.matches)), ""}
+
+	visible := height - 8
+	if visible < 1 {
+		visible = 1
+	}
+	start := 0
+	if g.cursor >= visible {
+		start = g.cursor - visible + 1
+	}
+	for i := start; i < len(g.matches) && i < start+visible; i++ {
+		match := g.matches[i]
+		line := truncateRunes(fmt.Sprintf("%s  %s", match.SessionID, match.Line), width-12)
+		if i == g.cursor {
+			line = lipgloss.NewStyle().Foreground(s.darkBg).Background(s.green).Render(line)
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "", "[ENTER] OPEN SESSION  [ESC] CLOSE")
+
+	return lipgloss.NewStyle().
+		BorderStyle(lipgloss.DoubleBorder()).
+		BorderForeground(s.green).
+		Background(s.darkBg).
+		Foreground(s.green).
+		Padding(0, 1).
+		Width(width - 4).
+		Render(strings.Join(lines, "\n"))
+}
+
+// truncateRunes shortens s to at most n runes, marking the cut with "…".
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if n < 1 || len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// sessionFile is the on-disk form of a saved session.
+type sessionFile struct {
+	ID            string         `json:"id"`
+	Messages      []Message      `json:"messages"`
+	MCPOps        []MCPOperation `json:"mcp_ops"`
+	ContextTokens int            `json:"context_tokens"`
+	Cost          float64        `json:"cost"`
+	Notes         string         `json:"notes,omitempty"`
+	SavedAt       time.Time      `json:"saved_at"`
+}
+
+type Model struct {
+	// Layout
+	width, height int
+
+	// Content
+	messages []Message
+	input    string
+	cursor   int // rune offset into input
+
+	// Vim-style editing, enabled with the "vim" command
+	vimEnabled bool
+	editorMode string // "insert" or "normal"
+	pendingOp  string // first key of a two-key command such as "dd"
+
+	// Emacs-style editing; ctrl+k kills instead of opening the palette
+	// only while emacsEnabled, set with the "emacs" command
+	emacsEnabled bool
+	killRing     []string // killed text, most recent last
+
+	prompt      string // editor prompt template, see promptText
+	cursorStyle string // key into cursorGlyphs
+	cursorBlink bool
+
+	// UI State
+	activePane      string // "messages", "editor", "mcp"
+	scrollOffset    int
+	showMCP         bool
+	altScreen       bool // the program is drawing in the alternate screen
+	showCommand     bool
+	commandInput    string
+	selStart        int      // selection anchor; equals selEnd for a single message
+	selEnd          int      // index of the focused selected message, -1 for none
+	maxContentWidth int      // cap on the wrap width of message text; 0 for none
+	spacing         int      // blank lines between messages
+	layoutRatio     [3]int   // messages:editor:mcp column split
+	layoutMode      string   // "auto", "horizontal" columns or "vertical" stack
+	modals          []Modal  // stack of open modals, top last
+	completions     []string // path candidates from the last palette tab
+
+	// Effects
+	noColor      bool            // NO_COLOR is set: no color and no CRT effects
+	profile      termenv.Profile // colors the terminal can show
+	theme        Theme           // palette the styles are built from
+	styles       *styles         // theme adapted to profile
+	glitchEffect bool
+	rain         *matrixRain // falling-character background; nil when off
+	scanlines    bool
+	scanlineY    int
+	bell         bool    // ring the terminal bell when a response fails
+	ringing      bool    // the view carries a BEL until BellDoneMsg
+	quiet        bool    // all effects are off; savedEffects restores them
+	plain        bool    // linear screen-reader layout; see renderPlain
+	savedEffects effects // effect flags from before quiet mode
+	frame        int     // ticks elapsed; drives marquees and cursor blink
+	fps          int     // animation frame rate; 0 is defaultFPS
+	ticking      bool    // a TickMsg is scheduled; false while idle, see resumeTicking
+	clockPending bool    // a ClockMsg is scheduled to refresh the status bar clock
+
+	// Boot sequence shown before the UI; see advanceBoot
+	booting   bool
+	bootTyped int // characters of bootLines typed so far
+	bootFade  int // ticks spent fading once typing finished
+
+	// Screensaver, shown after idleTimeout without a keypress
+	idleTimeout    time.Duration // 0 disables the screensaver
+	saverStyle     string        // one of screensaverStyles
+	lastInput      time.Time     // when the last key was pressed
+	screensaver    bool          // the screensaver is showing
+	saverStart     int           // frame the screensaver started on
+	toasts         []Toast
+	toastConfig    ToastConfig
+	notifications  *syncutil.RingBuffer[Toast] // every toast shown, newest last; shared across model copies
+	toastDurations map[string]time.Duration
+
+	// MCP Operations
+	mcpOps    []MCPOperation
+	mcpFilter string // status shown in the MCP panel; "" shows all
+
+	// Command output pane, toggled with ctrl+o or the "output" command
+	output       CommandOutput
+	showOutput   bool
+	isProcessing bool
+	provider     ResponseProvider
+	modelName    string                // registered name of provider
+	timeout      time.Duration         // how long provider may take to answer; 0 waits forever
+	retry        errorutil.RetryConfig // how failed provider calls are retried
+
+	// Session Info
+	sessionID      string
+	contextTokens  int
+	cost           float64
+	sessionsDir    string                // where sessions are saved by default
+	autoSave       bool                  // save the current session before starting a new one
+	greeting       string                // banner new sessions open with; "" is the default
+	systemPrompt   string                // persona shown at the top of new sessions and sent with each input
+	sendOnStart    bool                  // send the seeded input as soon as the program starts
+	latency        latencyTracker        // send-to-response times of this session
+	sendLimit      *syncutil.RateLimiter // limits sends when -rate-limit is set; nil allows all
+	redactPatterns []*regexp.Regexp      // secrets masked in exports
+	notes          string                // scratchpad saved with the session
+	displayUTC     bool                  // render timestamps in UTC instead of local time
+	showIDs        bool                  // prefix each message with its #ID
+
+	// updates carries messages posted by background goroutines; see post.
+	updates chan tea.Msg
+
+	// logger receives structured events when -log is set; nil discards.
+	logger *eventLogger
+
+	// lineCache memoizes wrapped message lines; shared across model copies.
+	lineCache *lineCache
+
+	// perf holds rolling Update and View timings for the perf command.
+	perf *perfStats
+
+	// stream is the reply being streamed in; nil when none is.
+	stream *responseStream
+
+	// autoScroll debounces scrolling to newly appended messages; nil
+	// disables auto-scroll.
+	autoScroll *autoScroller
+
+	// frames caches the last post-processed frame for applyScanline.
+	frames *frameCache
+
+	// tail follows a file into the conversation; nil when not tailing.
+	tail *tailer
+
+	// now is the model's clock; nil means time.Now.
+	now func() time.Time
+}
+
+// ============================================================================
+// Messages
+// ============================================================================
+
+type TickMsg time.Time
+type ProcessingDoneMsg struct {
+	messageID int // the user message being answered
+	replyID   int // assistant message already holding the streamed reply, or 0
+	response  string
+	tool      string
+	err       error
+}
+type GlitchMsg struct{}
+type ScanlineMsg struct{}
+
+// ClockMsg refreshes the status bar clock while the tick loop is stopped.
+type ClockMsg time.Time
+
+// SubmitMsg sends the editor input as if enter had been pressed.
+type SubmitMsg struct{}
+
+// BellDoneMsg takes the BEL back out of the view.
+type BellDoneMsg struct{}
+
+// logEvent is one JSON line of the structured log. Errors use the
+// errorutil.BaseError shape.
+type logEvent struct {
+	Time    time.Time            `json:"time"`
+	Level   string               `json:"level"` // "info" or "error"
+	Event   string               `json:"event"`
+	Message string               `json:"message,omitempty"`
+	Error   *errorutil.BaseError `json:"error,omitempty"`
+}
+
+// eventLogger writes structured JSON events to a log file, since stdout
+// belongs to the TUI. A nil logger discards everything.
+type eventLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+func newEventLogger(w io.Writer) *eventLogger {
+	return &eventLogger{enc: json.NewEncoder(w), now: time.Now}
+}
+
+// Event logs an informational event.
+func (l *eventLogger) Event(event, message string) {
+	if l == nil {
+		return
+	}
+	l.write(logEvent{Level: "info", Event: event, Message: message})
+}
+
+// Error logs err, converting it to a BaseError if it isn't one already.
+func (l *eventLogger) Error(event string, err error) {
+	if l == nil || err == nil {
+		return
+	}
+	var base *errorutil.BaseError
+	message := err.Error()
+	if errorutil.As(err, &base) {
+		message = base.Message
+	} else {
+		// The cause isn't serialized, so the line's message carries its text
+		base = errorutil.WrapWithCode(err, "ERROR", "unexpected error")
+	}
+	l.write(logEvent{Level: "error", Event: event, Message: message, Error: base})
+}
+
+func (l *eventLogger) write(e logEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Time = l.now()
+	// Logging must never disturb the UI, so write errors are dropped
+	_ = l.enc.Encode(e)
+}
+
+// effects are the visual and audible effect switches that quiet mode turns
+// off together.
+type effects struct {
+	glitch, scanlines, cursorBlink, bell, rain bool
+}
+
+// ConfirmMsg reports that the user confirmed the named action.
+type ConfirmMsg struct {
+	Action string
+}
+
+// RetryingMsg reports that the response to a message is being retried.
+type RetryingMsg struct {
+	messageID int
+	attempt   int // the attempt starting, from 2
+	attempts  int // the most that will be made
+}
+
+// PingDoneMsg carries the result of a provider health check.
+type PingDoneMsg struct {
+	latency time.Duration
+	err     error
+}
+
+// SummaryDoneMsg carries the provider's answer to a summarize command.
+type SummaryDoneMsg struct {
+	response string
+	err      error
+}
+
+// ChooseMsg reports the choice made in a listModal.
+type ChooseMsg struct {
+	Action string
+	Choice string
+}
+
+// GrepDoneMsg carries the result of a search across saved sessions.
+type GrepDoneMsg struct {
+	pattern string
+	matches []sessionMatch
+	err     error
+}
+
+// OpenSessionMsg asks for the session saved at Path to be opened.
+type OpenSessionMsg struct {
+	Path string
+}
+
+// NotesMsg replaces the session scratchpad with Text.
+type NotesMsg struct {
+	Text string
+}
+
+// ReplaceMsg asks for every literal occurrence of Find in the editor input
+// to be replaced with Replace.
+type ReplaceMsg struct {
+	Find, Replace string
+}
+
+// ============================================================================
+// Commands
+// ============================================================================
+
+// defaultFPS is the frame rate animations start at; the fps command sets
+// one between minFPS and maxFPS.
+const (
+	defaultFPS = 10
+	minFPS     = 5
+	maxFPS     = 60
+)
+
+// tickInterval is how often TickMsg drives toasts, the boot sequence,
+// progress bars and matrix rain at defaultFPS.
+const tickInterval = time.Second / defaultFPS
+
+// frameTiming holds the animation intervals for a frame rate.
+type frameTiming struct {
+	tick, glitch, scanline time.Duration
+}
+
+// timingForFPS returns the animation intervals for fps, clamped to
+// minFPS-maxFPS, and the frame rate actually used. A tick is one frame;
+// the glitch and scanline effects have their own loops, two and a bit
+// over three times as fast.
+func timingForFPS(fps int) (frameTiming, int) {
+	if fps < minFPS {
+		fps = minFPS
+	} else if fps > maxFPS {
+		fps = maxFPS
+	}
+	tick := time.Second / time.Duration(fps)
+	return frameTiming{
+		tick:     tick,
+		glitch:   tick / 2,
+		scanline: tick * 3 / 10,
+	}, fps
+}
+
+// timing returns the animation intervals for the model's frame rate.
+func (m Model) timing() frameTiming {
+	fps := m.fps
+	if fps == 0 {
+		fps = defaultFPS
+	}
+	t, _ := timingForFPS(fps)
+	return t
+}
+
+func tickCmd(d time.Duration) tea.Cmd {
+	return tea.Tick(d, func(t time.Time) tea.Msg {
+		return TickMsg(t)
+	})
+}
+
+func glitchCmd(d time.Duration) tea.Cmd {
+	return tea.Tick(d, func(t time.Time) tea.Msg {
+		return GlitchMsg{}
+	})
+}
+
+// bellDuration is how long the view carries the BEL, long enough for the
+// renderer to flush at least one frame with it.
+const bellDuration = 200 * time.Millisecond
+
+// bellCmd ends the ring started by setting ringing.
+func bellCmd() tea.Cmd {
+	return tea.Tick(bellDuration, func(time.Time) tea.Msg {
+		return BellDoneMsg{}
+	})
+}
+
+func scanlineCmd(d time.Duration) tea.Cmd {
+	return tea.Tick(d, func(t time.Time) tea.Msg {
+		return ScanlineMsg{}
+	})
+}
+
+// defaultResponseTimeout bounds how long a provider may take to answer.
+const defaultResponseTimeout = 30 * time.Second
+
+// callWithin runs call with a deadline of timeout, or none if timeout is 0.
+// A provider that ignores the deadline is abandoned when it passes, so the
+// caller gets an errorutil.TimeoutError on time either way.
+func callWithin(ctx context.Context, timeout time.Duration, call func(context.Context) (Response, error)) (Response, error) {
+	if timeout <= 0 {
+		return call(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		resp Response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := call(ctx)
+		done <- result{resp, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err == nil || ctx.Err() == nil {
+			return r.resp, r.err
+		}
+	case <-ctx.Done():
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return Response{}, errorutil.TimeoutError("respond", timeout)
+	}
+	return Response{}, ctx.Err()
+}
+
+// defaultRetryPolicy retries timeouts and network errors twice, backing
+// off from half a second.
+func defaultRetryPolicy() errorutil.RetryConfig {
+	policy := errorutil.DefaultRetryConfig()
+	policy.InitialDelay = 500 * time.Millisecond
+	policy.MaxDelay = 5 * time.Second
+	policy.Jitter = 0.2
+	return policy
+}
+
+// callWithRetry makes call, each attempt within timeout, retrying the
+// errors policy allows.
+func callWithRetry(ctx context.Context, policy errorutil.RetryConfig, timeout time.Duration, call func(context.Context) (Response, error)) (Response, error) {
+	if policy.MaxAttempts <= 1 {
+		return callWithin(ctx, timeout, call)
+	}
+	var resp Response
+	err := errorutil.Retry(ctx, policy, func() error {
+		var err error
+		resp, err = callWithin(ctx, timeout, call)
+		return err
+	})
+	return resp, err
+}
+
+// processCommand asks the provider to answer the user message with the
+// given ID and content, each attempt within timeout.
+func processCommand(ctx context.Context, provider ResponseProvider, messageID int, input string, timeout time.Duration, policy errorutil.RetryConfig) tea.Cmd {
+	return func() tea.Msg {
+		resp, err := callWithRetry(ctx, policy, timeout, func(ctx context.Context) (Response, error) {
+			return safeRespond(ctx, provider, input)
+		})
+		return ProcessingDoneMsg{
+			messageID: messageID,
+			response:  resp.Content,
+			tool:      resp.Tool,
+			err:       err,
+		}
+	}
+}
+
+// responseStream is a reply being streamed into the conversation. It is
+// only touched by Update; the stream goroutine talks to it through events.
+type responseStream struct {
+	messageID int             // the user message being answered
+	replyID   int             // the assistant message being filled; 0 before the first chunk
+	partial   string          // text received so far
+	ctx       context.Context // done once the stream is interrupted or over
+	cancel    context.CancelFunc
+	events    chan tea.Msg
+}
+
+// StreamChunkMsg is a piece of the reply of stream s.
+type StreamChunkMsg struct {
+	s    *responseStream
+	text string
+}
+
+// StreamDoneMsg reports that stream s ended with resp or err.
+type StreamDoneMsg struct {
+	s    *responseStream
+	resp Response
+	err  error
+}
+
+// startStream asks provider to stream its answer to the user message with
+// the given ID and content, each attempt finishing within timeout. Only
+// attempts that failed before streaming anything are retried.
+func startStream(ctx context.Context, provider StreamingProvider, messageID int, input string, timeout time.Duration, policy errorutil.RetryConfig) *responseStream {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &responseStream{messageID: messageID, ctx: ctx, cancel: cancel, events: make(chan tea.Msg)}
+
+	go func() {
+		// Once the end is delivered, release chunks still being sent by a
+		// provider that outlived its deadline
+		defer cancel()
+
+		send := func(msg tea.Msg) {
+			select {
+			case s.events <- msg:
+			case <-ctx.Done(): // interrupted; nobody is listening
+			}
+		}
+		var streamed atomic.Bool
+		if shouldRetry := policy.ShouldRetry; shouldRetry != nil {
+			policy.ShouldRetry = func(err error) bool {
+				return !streamed.Load() && shouldRetry(err)
+			}
+		}
+		resp, err := callWithRetry(ctx, policy, timeout, func(ctx context.Context) (Response, error) {
+			return safeStream(ctx, provider, input, func(text string) {
+				streamed.Store(true)
+				send(StreamChunkMsg{s: s, text: text})
+			})
+		})
+		send(StreamDoneMsg{s: s, resp: resp, err: err})
+	}()
+	return s
+}
+
+// waitForStream delivers the next event of s, or nothing once s is
+// interrupted.
+func waitForStream(s *responseStream) tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case msg := <-s.events:
+			return msg
+		case <-s.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// summarizeCmd asks the provider to answer a summary prompt.
+func summarizeCmd(ctx context.Context, provider ResponseProvider, prompt string) tea.Cmd {
+	return func() tea.Msg {
+		resp, err := safeRespond(ctx, provider, prompt)
+		return SummaryDoneMsg{response: resp.Content, err: err}
+	}
+}
+
+// ============================================================================
+// Model Implementation
+// ============================================================================
+
+// defaultGreeting is the banner new sessions open with.
+const defaultGreeting = "SYSTEM INITIALIZED. RETRO-DGMO v2.0 ONLINE."
+
+// welcomeMessage is the assistant's opening line in a new session.
+const welcomeMessage = "Welcome to the retro-futuristic terminal. How may I assist you today?"
+
+// greetingMessages are the messages every new session starts with: the
+// greeting banner, the system prompt if there is one, and a welcome. An
+// empty greeting uses defaultGreeting.
+func greetingMessages(now time.Time, greeting, systemPrompt string) []Message {
+	if greeting == "" {
+		greeting = defaultGreeting
+	}
+	messages := []Message{{
+		ID:        1,
+		Content:   greeting,
+		Role:      "system",
+		Timestamp: now,
+	}}
+	if systemPrompt != "" {
+		messages = append(messages, Message{
+			ID:        2,
+			Content:   systemPrompt,
+			Role:      "system",
+			Tool:      "system_prompt",
+			Timestamp: now,
+		})
+	}
+	return append(messages, Message{
+		ID:        len(messages) + 1,
+		Content:   welcomeMessage,
+		Role:      "assistant",
+		Timestamp: now,
+	})
+}
+
+// maxGreetingMessages is the most messages greetingMessages produces.
+const maxGreetingMessages = 3
+
+// greetingLength returns how many messages at the start of messages are
+// the greeting: the leading system messages and the welcome reply. Any of
+// them may have been deleted, or missing from a loaded session.
+func greetingLength(messages []Message) int {
+	limit := min(len(messages), maxGreetingMessages)
+	n := 0
+	for n < limit && messages[n].Role == "system" {
+		n++
+	}
+	if n < limit && messages[n].Role == "assistant" && messages[n].Content == welcomeMessage {
+		n++
+	}
+	return n
+}
+
+// withSystemPrompt prefixes the input sent to a provider with the system
+// prompt, so the persona reaches the provider and not just the transcript.
+func withSystemPrompt(systemPrompt, input string) string {
+	if systemPrompt == "" {
+		return input
+	}
+	return systemPrompt + "\n\n" + input
+}
+
+func newSessionID(now time.Time) string {
+	return fmt.Sprintf("RETRO-%d", now.Unix())
+}
+
+func initialModel() Model {
+	// Respect https://no-color.org: any non-empty NO_COLOR disables color
+	noColor := os.Getenv("NO_COLOR") != ""
+	profile := envColorProfile(os.Getenv)
+	if noColor {
+		profile = termenv.Ascii
+	}
+
+	return Model{
+		noColor:         noColor,
+		profile:         profile,
+		theme:           themes["classic"],
+		styles:          newStyles(themes["classic"], profile),
+		lineCache:       newLineCache(),
+		perf:            &perfStats{},
+		frames:          &frameCache{effectY: -1},
+		autoScroll:      newAutoScroller(defaultScrollDelay),
+		updates:         make(chan tea.Msg, updateBuffer),
+		ticking:         true, // Init starts the tick loop
+		messages:        greetingMessages(time.Now(), "", ""),
+		activePane:      "editor",
+		altScreen:       true,
+		selStart:        -1,
+		selEnd:          -1,
+		maxContentWidth: defaultMaxContentWidth,
+		spacing:         defaultSpacing,
+		layoutRatio:     defaultLayoutRatio,
+		layoutMode:      "auto",
+		provider:        providers[defaultModel],
+		modelName:       defaultModel,
+		timeout:         defaultResponseTimeout,
+		retry:           defaultRetryPolicy(),
+		redactPatterns:  defaultRedactPatterns,
+		sessionID:       newSessionID(time.Now()),
+		sessionsDir:     defaultSessionsDir(),
+		autoSave:        true,
+		contextTokens:   1337,
+		cost:            0.42,
+		showMCP:         true,
+		cursorStyle:     "block",
+		scanlines:       !noColor,
+		bell:            true,
+		toastConfig:     defaultToastConfig(),
+		notifications:   syncutil.NewRingBuffer[Toast](maxNotifications),
+		toastDurations:  defaultToastDurations(),
+		saverStyle:      "logo",
+		lastInput:       time.Now(),
+		mcpOps: []MCPOperation{
+			{ID: "OP-001", Tool: "system_check", Status: "completed", Progress: 100},
+		},
+	}
+}
+
+func (m Model) Init() tea.Cmd {
+	cmds := []tea.Cmd{
+		tea.EnterAltScreen,
+		tickCmd(m.timing().tick),
+	}
+	if m.scanlines {
+		cmds = append(cmds, scanlineCmd(m.timing().scanline))
+	}
+	if m.sendOnStart {
+		cmds = append(cmds, func() tea.Msg { return SubmitMsg{} })
+	}
+	if m.autoScroll != nil {
+		cmds = append(cmds, waitForAutoScroll(m.autoScroll))
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	// time.Now carries a monotonic reading, so wall clock jumps don't skew this
+	defer m.perf.recordUpdate(time.Now())
+	next, cmd := m.update(msg)
+	if m, ok := next.(Model); ok {
+		return m.resumeTicking(cmd)
+	}
+	return next, cmd
+}
+
+func (m Model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if m.booting {
+			// Any key skips the boot sequence
+			m.booting = false
+			return m, nil
+		}
+		if m.wake(m.clock()) {
+			// The key that dismisses the screensaver does nothing else
+			return m, nil
+		}
+		if len(m.modals) > 0 {
+			return m.updateModal(msg)
+		}
+
+		if m.inNormalMode() && m.handleNormalKey(msg.String()) {
+			return m, nil
+		}
+		if m.activePane == "messages" && !m.showCommand {
+			if cmd, ok := m.handleMessagesKey(msg.String()); ok {
+				return m, cmd
+			}
+		}
+		if m.activePane == "mcp" && !m.showCommand && msg.String() == "f" {
+			m.mcpFilter = nextMCPFilter(m.mcpFilter)
+			return m, nil
+		}
+
+		switch msg.String() {
+		case "ctrl+c", "ctrl+q":
+			return m, tea.Quit
+
+		case "tab":
+			if m.showCommand {
+				m.completeCommandPath()
+				break
+			}
+
+			// Cycle through panes
+			switch m.activePane {
+			case "messages":
+				m.activePane = "editor"
+			case "editor":
+				if m.showOutput {
+					m.activePane = "output"
+				} else if m.showMCP {
+					m.activePane = "mcp"
+				} else {
+					m.activePane = "messages"
+				}
+			case "output":
+				if m.showMCP {
+					m.activePane = "mcp"
+				} else {
+					m.activePane = "messages"
+				}
+			case "mcp":
+				m.activePane = "messages"
+			}
+
+		case "shift+tab":
+			return m, m.toggleAltScreen()
+
+		case "ctrl+o":
+			m.toggleOutput()
+
+		case "ctrl+x":
+			m.interruptStream()
+
+		case "ctrl+m":
+			m.showMCP = !m.showMCP
+			if !m.showMCP && m.activePane == "mcp" {
+				m.activePane = "editor"
+			}
+			toast := "MCP PANEL: ACTIVATED"
+			if !m.showMCP {
+				toast = "MCP PANEL: DEACTIVATED"
+			}
+			m.addToast(toast, "info")
+
+		case "ctrl+t", "ctrl+u", "ctrl+y":
+			if m.activePane == "editor" && m.canAcceptInput() {
+				m.handleEmacsKey(msg.String())
+			}
+
+		case "ctrl+k", "alt+x":
+			if msg.String() == "ctrl+k" && m.emacsEnabled && m.activePane == "editor" && m.canAcceptInput() {
+				m.handleEmacsKey("ctrl+k")
+				break
+			}
+			m.showCommand = !m.showCommand
+			if m.showCommand {
+				m.commandInput = ""
+				m.completions = nil
+			}
+
+		case "esc":
+			if m.showCommand {
+				m.showCommand = false
+			} else if m.vimEnabled && m.activePane == "editor" && m.editorMode != "normal" {
+				m.editorMode = "normal"
+			} else {
+				m.dismissToast()
+			}
+
+		case "ctrl+d":
+			m.dismissToast()
+
+		case "alt+d":
+			// Terminals report ctrl+shift+d as ctrl+d, so clear-all lives on alt+d
+			m.toasts = nil
+
+		case "alt+i":
+			// ctrl+i arrives as tab, which already switches panes
+			m.toggleIDs()
+
+		case "ctrl+r":
+			if m.activePane == "editor" && m.canAcceptInput() {
+				m.pushModal(replaceModal{})
+			}
+
+		case "ctrl+g":
+			// Toggle glitch effect; quiet mode keeps it off
+			if m.noColor || m.quiet {
+				break
+			}
+			m.glitchEffect = !m.glitchEffect
+			if m.glitchEffect {
+				return m, glitchCmd(m.timing().glitch)
+			}
+
+		case "enter":
+			if m.showCommand {
+				// Execute command
+				cmd := m.executeCommand()
+				m.showCommand = false
+				return m, cmd
+			} else if m.activePane == "editor" && m.input != "" && m.canAcceptInput() {
+				return m, m.sendInput()
+			}
+
+		case "backspace":
+			if m.showCommand && len(m.commandInput) > 0 {
+				runes := []rune(m.commandInput)
+				m.commandInput = string(runes[:prevGrapheme(m.commandInput, len(runes))])
+				m.completions = nil
+			} else if m.activePane == "editor" && m.canAcceptInput() && m.cursor > 0 {
+				// One keypress removes one visible character, however
+				// many runes (combining marks, emoji sequences) it has
+				from := prevGrapheme(m.input, m.cursor)
+				m.deleteInput(from, m.cursor)
+				m.cursor = from
+			}
+
+		case "delete":
+			if m.activePane == "editor" && m.canAcceptInput() && m.cursor < m.inputLen() {
+				// Forward delete: the cursor stays where it is
+				m.deleteInput(m.cursor, nextGrapheme(m.input, m.cursor))
+			}
+
+		case "left":
+			if m.activePane == "editor" && m.cursor > 0 {
+				m.cursor = prevGrapheme(m.input, m.cursor)
+			}
+
+		case "right":
+			if m.activePane == "editor" && m.cursor < m.inputLen() {
+				m.cursor = nextGrapheme(m.input, m.cursor)
+			}
+
+		case "up":
+			if m.activePane == "messages" && m.scrollOffset > 0 {
+				m.scrollOffset--
+			} else if m.activePane == "output" {
+				m.output.Scroll(1)
+			}
+
+		case "down":
+			if m.activePane == "messages" && m.scrollOffset < m.maxScrollOffset() {
+				m.scrollOffset++
+			} else if m.activePane == "output" {
+				m.output.Scroll(-1)
+			}
+
+		case "alt+up", "shift+up":
+			if m.activePane == "messages" {
+				m.selectMessage(-1, msg.String() == "shift+up")
+			}
+
+		case "alt+down", "shift+down":
+			if m.activePane == "messages" {
+				m.selectMessage(1, msg.String() == "shift+down")
+			}
+
+		case "pgup":
+			if m.activePane == "messages" {
+				m.scrollOffset -= m.pageSize()
+				if m.scrollOffset < 0 {
+					m.scrollOffset = 0
+				}
+			}
+
+		case "pgdown":
+			if m.activePane == "messages" {
+				m.scrollOffset += m.pageSize()
+				if max := m.maxScrollOffset(); m.scrollOffset > max {
+					m.scrollOffset = max
+				}
+			}
+
+		default:
+			if !m.showCommand && isPrintableKey(msg) {
+				// Focus follows typing: printable keys always reach the editor
+				m.activePane = "editor"
+			}
+			if m.showCommand {
+				m.commandInput += msg.String()
+				m.completions = nil
+			} else if m.activePane == "editor" && m.canAcceptInput() {
+				m.insertInput(msg.String())
+			}
+		}
+
+	case tea.WindowSizeMsg:
+		// Wrapped lines are cached per pane width, so the next render wraps
+		// at the new width (or reuses a recent one); an in-flight request is
+		// unaffected and its response is laid out at the new size.
+		before := m.layout()
+		sized := m.width > 0
+		m.width = msg.Width
+		m.height = msg.Height
+		if after := m.layout(); sized && after != before {
+			m.addToast("LAYOUT: "+strings.ToUpper(after), "info")
+		}
+		if m.scrollOffset > m.maxScrollOffset() {
+			m.scrollOffset = m.maxScrollOffset()
+		}
+
+	case TickMsg:
+		// Update MCP operations
+		m.frame++
+		for i := range m.mcpOps {
+			if m.mcpOps[i].Status == "running" && m.mcpOps[i].Progress >= 0 {
+				m.mcpOps[i].Progress += 10
+				if m.mcpOps[i].Progress >= 100 {
+					m.mcpOps[i].Progress = 100
+					m.mcpOps[i].Status = "completed"
+				}
+			}
+		}
+
+		// Clean expired toasts
+		var activeToasts []Toast
+		now := m.clock()
+		for _, toast := range m.toasts {
+			if toast.ExpiresAt.After(now) {
+				activeToasts = append(activeToasts, toast)
+			}
+		}
+		m.toasts = activeToasts
+		m.checkIdle(now)
+		m.rain.advance(m.width, m.height)
+		if m.booting {
+			m.advanceBoot()
+		}
+
+		if m.animating() {
+			return m, tickCmd(m.timing().tick)
+		}
+		// Nothing moves, so stop until resumeTicking sees something that does
+		m.ticking = false
+		return m, m.scheduleClock()
+
+	case ClockMsg:
+		m.clockPending = false
+		if !m.ticking {
+			return m, m.scheduleClock()
+		}
+
+	case SubmitMsg:
+		if m.input != "" && m.canAcceptInput() {
+			return m, m.sendInput()
+		}
+
+	case ConfirmMsg:
+		switch msg.Action {
+		case "new":
+			m.startNewSession()
+		}
+
+	case NotesMsg:
+		m.notes = msg.Text
+		m.addToast("NOTES SAVED", "success")
+
+	case ReplaceMsg:
+		input, n, err := replaceLiteral(m.input, msg.Find, msg.Replace)
+		if err != nil {
+			m.addToast(strings.ToUpper(err.Error()), "error")
+			break
+		}
+		m.input = input
+		if m.cursor > m.inputLen() {
+			m.cursor = m.inputLen()
+		}
+		m.addToast(fmt.Sprintf("%d REPLACEMENT(S)", n), "info")
+
+	case ProcessingDoneMsg:
+		return m, m.finishResponse(msg)
+
+	case PingDoneMsg:
+		if msg.err != nil {
+			m.addToast("PING FAILED: "+strings.ToUpper(msg.err.Error()), "error")
+			break
+		}
+		m.addToast(fmt.Sprintf("PONG FROM %s IN %s", strings.ToUpper(m.modelName),
+			msg.latency.Round(time.Millisecond)), "success")
+
+	case RetryingMsg:
+		if m.isProcessing {
+			m.addToast(fmt.Sprintf("RETRYING (%d/%d)", msg.attempt, msg.attempts), "info")
+		}
+
+	case StreamChunkMsg:
+		st := m.stream
+		if msg.s != st {
+			break // from an interrupted stream
+		}
+		st.partial += msg.text
+		if st.replyID == 0 {
+			st.replyID = m.nextMessageID()
+			m.appendMessage(Message{
+				ID:        st.replyID,
+				Content:   st.partial,
+				Role:      "assistant",
+				Timestamp: time.Now(),
+			})
+		} else if i := m.messageIndex(st.replyID); i >= 0 {
+			m.messages[i].Content = st.partial
+			if m.scrollOffset >= m.maxScrollOffset()-1 {
+				m.autoScroll.Request()
+			}
+		}
+		return m, waitForStream(st)
+
+	case StreamDoneMsg:
+		if msg.s != m.stream {
+			break
+		}
+		m.stream = nil
+		return m, m.finishResponse(ProcessingDoneMsg{
+			messageID: msg.s.messageID,
+			replyID:   msg.s.replyID,
+			response:  msg.resp.Content,
+			tool:      msg.resp.Tool,
+			err:       msg.err,
+		})
+
+	case AutoScrollMsg:
+		m.scrollOffset = m.maxScrollOffset()
+		return m, waitForAutoScroll(m.autoScroll)
+
+	case TailLineMsg:
+		if msg.t != m.tail {
+			break // from a tail that has since been stopped
+		}
+		m.appendMessage(Message{
+			ID:        m.nextMessageID(),
+			Content:   msg.line,
+			Role:      "system",
+			Timestamp: time.Now(),
+			Tool:      "tail",
+		})
+		return m, waitForTailLine(msg.t)
+
+	case TailStoppedMsg:
+		if msg.t != m.tail {
+			break
+		}
+		m.tail = nil
+		if msg.err != nil {
+			m.logger.Error("tail", msg.err)
+			m.addToast("TAIL STOPPED: "+strings.ToUpper(msg.err.Error()), "error")
+		}
+
+	case ChooseMsg:
+		switch msg.Action {
+		case "model":
+			if err := m.setModel(msg.Choice); err != nil {
+				m.addToast(strings.ToUpper(err.Error()), "error")
+			}
+		}
+
+	case GrepDoneMsg:
+		if msg.err != nil {
+			m.addToast("GREP FAILED: "+strings.ToUpper(msg.err.Error()), "error")
+			break
+		}
+		m.pushModal(grepModal{pattern: msg.pattern, matches: msg.matches})
+
+	case OpenSessionMsg:
+		sf, err := loadSession(msg.Path)
+		if err != nil {
+			m.addToast("OPEN FAILED: "+err.Error(), "error")
+			break
+		}
+		if m.autoSave {
+			if err := m.saveSession(m.sessionPath()); err != nil {
+				m.addToast("AUTO-SAVE FAILED: "+err.Error(), "error")
+			}
+		}
+		m.applySession(sf)
+		m.addToast("OPENED SESSION: "+m.sessionID, "success")
+
+	case SummaryDoneMsg:
+		m.isProcessing = false
+		if msg.err != nil {
+			m.logger.Error("summary", msg.err)
+			m.addToast("SUMMARY FAILED: "+strings.ToUpper(msg.err.Error()), "error")
+			break
+		}
+		m.appendMessage(Message{
+			ID:        m.nextMessageID(),
+			Content:   "SUMMARY: " + msg.response,
+			Role:      "system",
+			Timestamp: time.Now(),
+			Tool:      "summary",
+		})
+		m.addToast("SUMMARY COMPLETE", "success")
+
+	case BellDoneMsg:
+		m.ringing = false
+
+	case GlitchMsg:
+		if m.glitchEffect {
+			return m, glitchCmd(m.timing().glitch)
+		}
+
+	case ScanlineMsg:
+		if !m.scanlines {
+			break
+		}
+		if m.height > 0 {
+			m.scanlineY = (m.scanlineY + 1) % m.height
+		}
+		return m, scanlineCmd(m.timing().scanline)
+	}
+
+	return m, nil
+}
+
+func (m Model) View() string {
+	defer m.perf.recordView(time.Now())
+	return m.view()
+}
+
+func (m Model) view() string {
+	if m.width == 0 || m.height == 0 {
+		return "INITIALIZING..."
+	}
+	if m.plain {
+		return m.renderPlain()
+	}
+	if m.booting {
+		return m.renderBoot()
+	}
+	if m.screensaver {
+		return m.renderScreensaver()
+	}
+
+	// Build layout
+	var content string
+
+	// Title bar
+	title := m.styles.titleBar.Width(m.width).Render("◼ RETRO-DGMO TERMINAL v2.0 ◼")
+
+	// Main content area
+	mainHeight := m.mainHeight()
+
+	if m.layout() == "vertical" {
+		// Stacked layout: each pane spans the full width
+		messagesHeight, editorHeight, mcpHeight := m.rowHeights()
+
+		panes := []string{
+			m.renderMessages(m.width, messagesHeight),
+			m.renderEditor(m.width, editorHeight),
+		}
+		if m.showOutput {
+			panes = append(panes, m.renderOutput(m.width, m.outputHeight()))
+		}
+		if m.showMCP {
+			panes = append(panes, m.renderMCP(m.width, mcpHeight))
+		}
+		content = lipgloss.JoinVertical(lipgloss.Left, panes...)
+	} else if m.showMCP {
+		// Three-column layout
+		messagesWidth, editorWidth, mcpWidth := m.columnWidths()
+
+		messages := m.renderMessages(messagesWidth, mainHeight)
+		editor := m.renderEditorColumn(editorWidth, mainHeight)
+		mcp := m.renderMCP(mcpWidth, mainHeight)
+
+		content = lipgloss.JoinHorizontal(lipgloss.Top, messages, editor, mcp)
+	} else {
+		// Two-column layout
+		messagesWidth, editorWidth, _ := m.columnWidths()
+
+		messages := m.renderMessages(messagesWidth, mainHeight)
+		editor := m.renderEditorColumn(editorWidth, mainHeight)
+
+		content = lipgloss.JoinHorizontal(lipgloss.Top, messages, editor)
+	}
+
+	// Status bar
+	status := m.renderStatus()
+
+	// Command palette overlay
+	if m.showCommand {
+		content = m.renderCommandPalette(content)
+	}
+
+	// Modal overlay
+	if modal := m.topModal(); modal != nil {
+		content = m.renderModal(content, modal)
+	}
+
+	// Toast overlay
+	if len(m.toasts) > 0 {
+		content = m.renderToasts(content)
+	}
+
+	// Apply CRT effects
+	final := lipgloss.JoinVertical(lipgloss.Left, title, content, status)
+
+	final = m.rain.apply(final, m.styles)
+	if m.glitchEffect {
+		final = m.applyGlitch(final)
+	}
+	final = m.applyScanline(final)
+
+	// The bell goes out with the frame so it shares Bubble Tea's output
+	if m.ringing {
+		final += "\a"
+	}
+	return final
+}
+
+// ============================================================================
 // Render Functions
 // ============================================================================
 
-func (m Model) renderMessages(width, height int) string {
-	style := borderStyle.Width(width - 2).Height(height - 2)
-	if m.activePane == "messages" {
-		style = style.BorderForeground(crtAmber)
+func (m Model) renderMessages(width, height int) string {
+	style := m.styles.border.Width(safeWidth(width-2, 0)).Height(safeWidth(height-2, 0))
+	if m.activePane == "messages" {
+		style = style.BorderForeground(m.styles.amber)
+	}
+
+	title := " MESSAGES "
+	content := m.messageLines(width)
+
+	// Apply scrolling
+	visible := safeWidth(height-4, 0)
+	visibleContent := content
+	start, end := 0, len(content)
+	if len(content) > visible {
+		start = m.scrollOffset
+		if start > len(content)-visible {
+			start = len(content) - visible
+		}
+		if start < 0 {
+			start = 0
+		}
+		end = start + visible
+		if end > len(content) {
+			end = len(content)
+		}
+		visibleContent = content[start:end]
+	}
+
+	// The pane has a spare line below the content for the bottom indicator
+	above, below := scrollIndicators(start, len(content)-end)
+	if above != "" {
+		title += m.styles.muted.Render(above)
+	}
+	inner := strings.Join(visibleContent, "\n")
+	if below != "" {
+		inner += "\n" + m.styles.muted.Render(below)
+	}
+	return style.Render(lipgloss.JoinVertical(lipgloss.Left, title, inner))
+}
+
+// scrollIndicators describes how many lines are hidden above and below the
+// messages viewport; each is empty when nothing is hidden on that side.
+func scrollIndicators(above, below int) (string, string) {
+	var top, bottom string
+	if above > 0 {
+		top = fmt.Sprintf(" ▲ %d more", above)
+	}
+	if below > 0 {
+		bottom = fmt.Sprintf("▼ %d more", below)
+	}
+	return top, bottom
+}
+
+// messageLines renders every message into wrapped, styled lines for a
+// messages pane of the given width.
+func (m Model) messageLines(width int) []string {
+	content := make([]string, 0, len(m.messages)*4)
+	width = m.messageColumnWidth(width)
+
+	for i, msg := range m.messages {
+		selected := m.isSelected(i)
+		lines, ok := m.lineCache.get(width, msg, selected)
+		if !ok {
+			lines = m.renderMessage(msg, width, selected)
+			m.lineCache.put(width, msg, selected, lines)
+		}
+		content = append(content, lines...)
+		for j := 0; j < m.spacing; j++ {
+			content = append(content, "")
+		}
+	}
+
+	return content
+}
+
+// defaultMaxContentWidth caps message text so lines stay readable on wide
+// terminals; narrower caps than minContentWidth are refused.
+const (
+	defaultMaxContentWidth = 100
+	minContentWidth        = 20
+)
+
+// setMaxContentWidth caps the wrap width of message text at n columns, or
+// removes the cap if n is 0.
+func (m *Model) setMaxContentWidth(n int) error {
+	if n != 0 && n < minContentWidth {
+		return fmt.Errorf("width must be 0 or at least %d", minContentWidth)
+	}
+	m.maxContentWidth = n
+	if max := m.maxScrollOffset(); m.scrollOffset > max {
+		m.scrollOffset = max
+	}
+	return nil
+}
+
+// defaultSpacing and maxSpacing bound the blank lines between messages.
+const (
+	defaultSpacing = 1
+	maxSpacing     = 3
+)
+
+// setSpacing changes the blank lines between messages, keeping the scroll
+// position within the new bounds.
+func (m *Model) setSpacing(n int) error {
+	if n < 0 || n > maxSpacing {
+		return fmt.Errorf("spacing must be 0-%d", maxSpacing)
+	}
+	m.spacing = n
+	if max := m.maxScrollOffset(); m.scrollOffset > max {
+		m.scrollOffset = max
+	}
+	return nil
+}
+
+// messageColumnWidth narrows a messages pane width so wrapped text is at
+// most maxContentWidth columns; the column stays left-aligned in the pane.
+func (m Model) messageColumnWidth(paneWidth int) int {
+	// renderMessage wraps at width-8 (pane border, box border and padding)
+	if m.maxContentWidth > 0 && paneWidth-8 > m.maxContentWidth {
+		return m.maxContentWidth + 8
+	}
+	return paneWidth
+}
+
+// isLongMessage reports whether content wraps to more lines than
+// collapseThreshold in the messages pane at its current width.
+func (m Model) isLongMessage(content string) bool {
+	width := defaultMaxContentWidth
+	if m.width > 0 {
+		width = m.messageColumnWidth(m.messagesWidth()) - 8
+	}
+	return len(wordWrap(content, width)) > collapseThreshold
+}
+
+// renderMessage renders one message for a messages pane of the given width
+// and splits the result into terminal lines.
+func (m Model) renderMessage(msg Message, width int, selected bool) []string {
+	var msgStyle lipgloss.Style
+	prefix := ""
+
+	switch msg.Role {
+	case "user":
+		msgStyle = m.styles.userMsg.Width(safeWidth(width-6, 1))
+		prefix = "USER> "
+	case "assistant":
+		msgStyle = m.styles.aiMsg.Width(safeWidth(width-6, 1))
+		prefix = "AI> "
+		if badge, ok := toolBadges[msg.Tool]; ok {
+			prefix = "AI" + badge.text() + "> "
+		} else if msg.Tool != "" {
+			prefix = fmt.Sprintf("AI[%s]> ", msg.Tool)
+		}
+	case "system":
+		msgStyle = lipgloss.NewStyle().Foreground(m.styles.green).Bold(true)
+		prefix = "SYS> "
+	}
+
+	stamp := "[" + formatTimestamp(msg.Timestamp, m.displayUTC) + "] "
+	if msg.Reaction != "" {
+		stamp = msg.Reaction + " " + stamp
+	}
+	if msg.Pinned {
+		stamp = "📌 " + stamp
+	}
+	if m.showIDs {
+		stamp = fmt.Sprintf("#%d ", msg.ID) + stamp
+	}
+	text := stamp + prefix + msg.Content
+	if msg.Failed {
+		msgStyle = msgStyle.BorderForeground(m.styles.red)
+		text += " [FAILED: " + msg.Error + " - R TO RETRY]"
+	}
+	if selected {
+		msgStyle = msgStyle.BorderForeground(m.styles.amber)
+		if msg.Role == "system" {
+			text = "▶ " + text
+		}
+	}
+
+	var lines []string
+	for _, seg := range splitSegments(text) {
+		if seg.code {
+			// Code keeps its own line breaks; the box wraps overlong lines
+			lines = append(lines, strings.Split(highlightCode(seg.text, seg.lang, m.styles), "\n")...)
+			continue
+		}
+		lines = append(lines, wrapLinks(seg.text, width-8)...)
+	}
+	if msg.Collapsed && len(lines) > collapsedLines {
+		lines = append(lines[:collapsedLines:collapsedLines], "… show more")
+	}
+	if badge, ok := toolBadges[msg.Tool]; ok && msg.Role == "assistant" && len(lines) > 0 {
+		// Colored after wrapping, so the escape sequences don't count as
+		// width; the badge's reset would drop the message color after it
+		if before, after, found := strings.Cut(lines[0], badge.text()); found {
+			rest := lipgloss.NewStyle().Foreground(msgStyle.GetForeground())
+			lines[0] = before + badge.render(m.styles) + rest.Render(after)
+		}
+		if selected {
+			lines = append(lines, m.styles.muted.Render(truncateRunes("⤷ "+msg.Tool+": "+badge.detail, safeWidth(width-8, 1))))
+		}
+	}
+	var rendered []string
+	for _, line := range lines {
+		rendered = append(rendered, strings.Split(msgStyle.Render(line), "\n")...)
+	}
+	return rendered
+}
+
+func (m Model) renderEditor(width, height int) string {
+	style := m.styles.editor.Width(safeWidth(width-2, 0)).Height(safeWidth(height-2, 0))
+	if m.activePane == "editor" {
+		style = style.BorderForeground(m.styles.pink)
+	}
+
+	title := " COMMAND INPUT "
+
+	prompt := m.promptText()
+	if m.isProcessing {
+		prompt = "◊ PROCESSING... "
+	}
+
+	// Input with cursor, hard-wrapped to the editor's inner width with one
+	// cell reserved for the cursor glyph
+	rows := wrapInput(prompt+m.input, width-5)
+	row, col := cursorPosition(prompt+m.input, utf8.RuneCountInString(prompt)+m.cursor, width-5)
+	for len(rows) <= row {
+		rows = append(rows, nil)
+	}
+	cursorRow := append([]rune{}, rows[row][:col]...)
+	cursorRow = append(cursorRow, []rune(m.cursorGlyph())...)
+	rows[row] = append(cursorRow, rows[row][col:]...)
+
+	inputRows := make([]string, len(rows))
+	for i, r := range rows {
+		inputRows[i] = string(r)
+	}
+	inputLine := lipgloss.NewStyle().Foreground(m.styles.amber).Render(strings.Join(inputRows, "\n"))
+
+	// Help text
+	help := []string{
+		"",
+		"COMMANDS:",
+		"TAB      - Switch panes",
+		"CTRL+M   - Toggle MCP panel",
+		"CTRL+K   - Command palette",
+		"CTRL+G   - Glitch effect",
+		"CTRL+C   - Exit",
+		"",
+		"STATUS: " + strings.ToUpper(fmt.Sprintf("Ready")),
+	}
+
+	if m.isProcessing {
+		help[len(help)-1] = "STATUS: PROCESSING..."
+	}
+
+	helpText := lipgloss.NewStyle().Foreground(m.styles.green).Render(strings.Join(help, "\n"))
+
+	content := lipgloss.JoinVertical(lipgloss.Left, inputLine, "", helpText)
+
+	// Short panes (e.g. the vertical layout) drop the help text first
+	return style.Render(clipLines(lipgloss.JoinVertical(lipgloss.Left, title, content), height-4))
+}
+
+// renderEditorColumn renders the editor, with the command output pane
+// below it when shown, for the columns layout.
+func (m Model) renderEditorColumn(width, height int) string {
+	if !m.showOutput {
+		return m.renderEditor(width, height)
+	}
+	outputHeight := m.outputHeight()
+	return lipgloss.JoinVertical(lipgloss.Left,
+		m.renderEditor(width, height-outputHeight),
+		m.renderOutput(width, outputHeight))
+}
+
+func (m Model) renderOutput(width, height int) string {
+	style := m.styles.mcpPanel.Width(safeWidth(width-2, 0)).Height(safeWidth(height-2, 0)).Padding(0, 1)
+	if m.activePane == "output" {
+		style = style.BorderForeground(m.styles.amber)
+	}
+
+	lines := m.output.Visible(safeWidth(height-3, 0))
+	if len(lines) == 0 {
+		lines = []string{m.styles.muted.Render("(no command output)")}
+	}
+	body := lipgloss.NewStyle().Foreground(m.styles.green).Render(strings.Join(lines, "\n"))
+	return style.Render(clipLines(lipgloss.JoinVertical(lipgloss.Left, " OUTPUT ", body), height-2))
+}
+
+func (m Model) renderMCP(width, height int) string {
+	style := m.styles.mcpPanel.Width(safeWidth(width-2, 0)).Height(safeWidth(height-2, 0))
+	if m.activePane == "mcp" {
+		style = style.BorderForeground(m.styles.amber)
+	}
+
+	title := " MCP OPS "
+	if m.mcpFilter != "" {
+		title += "[" + strings.ToUpper(m.mcpFilter) + "] "
+	}
+	content := []string{}
+
+	for _, op := range filterMCPOps(m.mcpOps, m.mcpFilter) {
+		status := "◼"
+		if op.Status == "running" {
+			status = "◊"
+		} else if op.Status == "completed" {
+			status = "◆"
+		}
+
+		progress := ""
+		if op.Status == "running" {
+			bar := ProgressBar{}.Render(10, op.Progress, lipgloss.NewStyle())
+			if op.Progress < 0 {
+				bar = ProgressBar{}.RenderIndeterminate(10, m.frame, lipgloss.NewStyle())
+			}
+			progress = "\n[" + bar + "]"
+		}
+
+		opText := fmt.Sprintf("%s %s\n%s%s", status, op.ID, op.Tool, progress)
+
+		color := m.styles.purple
+		if op.Status == "completed" {
+			color = m.styles.green
+		} else if op.Status == "running" {
+			color = m.styles.amber
+		}
+
+		content = append(content, lipgloss.NewStyle().Foreground(color).Render(opText))
+		content = append(content, "")
+	}
+
+	inner := strings.Join(content, "\n")
+	return style.Render(clipLines(lipgloss.JoinVertical(lipgloss.Left, title, inner), height-4))
+}
+
+// mcpFilters is the order the MCP panel's f key cycles through; "" is all.
+var mcpFilters = []string{"", "running", "completed", "failed", "cancelled"}
+
+// nextMCPFilter returns the filter after current in mcpFilters.
+func nextMCPFilter(current string) string {
+	for i, f := range mcpFilters {
+		if f == current {
+			return mcpFilters[(i+1)%len(mcpFilters)]
+		}
+	}
+	return ""
+}
+
+// filterMCPOps returns the ops whose status is status; "" keeps them all.
+func filterMCPOps(ops []MCPOperation, status string) []MCPOperation {
+	if status == "" {
+		return ops
+	}
+	var filtered []MCPOperation
+	for _, op := range ops {
+		if op.Status == status {
+			filtered = append(filtered, op)
+		}
+	}
+	return filtered
+}
+
+// defaultPrompt is the editor prompt when none is configured.
+const defaultPrompt = "> "
+
+// promptText expands the configured prompt; {mode} becomes the vim mode,
+// {model} the provider name and {session} the session ID. With vim on and
+// no {mode} in the prompt the mode is shown in front of it.
+func (m Model) promptText() string {
+	prompt := m.prompt
+	if prompt == "" {
+		prompt = defaultPrompt
+	}
+
+	mode := ""
+	if m.vimEnabled {
+		mode = strings.ToUpper(m.editorMode)
+		if !strings.Contains(prompt, "{mode}") {
+			prompt = "[" + mode + "] " + prompt
+		}
+	}
+	return strings.NewReplacer("{mode}", mode, "{model}", m.modelName, "{session}", m.sessionID).Replace(prompt)
+}
+
+// safeWidth clamps a width (or height) computed by subtracting borders and
+// padding to at least min, so a tiny terminal can't produce the negative
+// sizes that make slicing and strings.Repeat panic.
+func safeWidth(w, min int) int {
+	if w < min {
+		return min
+	}
+	return w
+}
+
+// clipLines keeps at most n lines of s so a pane never grows past its
+// height.
+func clipLines(s string, n int) string {
+	if n < 1 {
+		return ""
+	}
+	lines := strings.Split(s, "\n")
+	if len(lines) <= n {
+		return s
+	}
+	return strings.Join(lines[:n], "\n")
+}
+
+func (m Model) renderStatus() string {
+	left := fmt.Sprintf(" SESSION: %s | MODEL: %s | TOKENS: %d | COST: $%.2f ",
+		m.sessionID, strings.ToUpper(m.modelName), m.contextTokens, m.cost)
+
+	right := fmt.Sprintf(" %s | MEM: 64KB | CPU: 99%% ", formatTimestamp(m.clock(), m.displayUTC))
+
+	gap := m.width - lipgloss.Width(left) - lipgloss.Width(right)
+	if gap < 0 {
+		gap = 0
+	}
+
+	status := left + strings.Repeat("─", gap) + right
+
+	return m.styles.statusBar.Width(m.width).Render(status)
+}
+
+func (m Model) renderCommandPalette(content string) string {
+	width := 60
+	height := 3
+
+	x := (m.width - width) / 2
+	y := (m.height - height) / 2
+
+	palette := lipgloss.NewStyle().
+		BorderStyle(lipgloss.DoubleBorder()).
+		BorderForeground(m.styles.pink).
+		Background(m.styles.darkBg).
+		Foreground(m.styles.pink).
+		Width(width).
+		Height(height).
+		Padding(1).
+		Render("COMMAND> " + m.commandInput + "▊" + m.renderCompletions(width))
+
+	return overlayBlock(content, palette, x, y)
+}
+
+// renderCompletions lists the path candidates below the palette input,
+// truncated to a single line of the palette width.
+func (m Model) renderCompletions(width int) string {
+	if len(m.completions) == 0 {
+		return ""
+	}
+
+	line := strings.Join(m.completions, "  ")
+	if runes := []rune(line); len(runes) > width-2 {
+		line = string(runes[:width-3]) + "…"
+	}
+	return "\n" + lipgloss.NewStyle().Foreground(m.styles.mediumGray).Render(line)
+}
+
+// renderModal centers the modal's view over the content.
+func (m Model) renderModal(content string, modal Modal) string {
+	width := m.width * 6 / 10
+	if width < 30 {
+		width = m.width
+	}
+	view := modal.View(width, m.mainHeight(), m.styles)
+
+	x := (m.width - lipgloss.Width(view)) / 2
+	y := (m.mainHeight() - lipgloss.Height(view)) / 2
+	return overlayBlock(content, view, x, y)
+}
+
+func (m Model) renderToasts(content string) string {
+	shown, hidden := m.visibleToasts()
+
+	views := make([]string, 0, len(shown)+1)
+	for _, toast := range shown {
+		views = append(views, m.renderToast(toast))
+	}
+	if hidden > 0 {
+		views = append(views, m.styles.toast.Render(fmt.Sprintf("+%d more", hidden)))
+	}
+
+	lines := strings.Split(content, "\n")
+	for i, view := range views {
+		x, y := m.toastPosition(i, lipgloss.Width(view), len(lines))
+		if y >= 0 && y < len(lines) {
+			lines[y] = overlayString(lines[y], view, x, 0)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// renderToast draws a single toast with the color and glyph of its type.
+func (m Model) renderToast(toast Toast) string {
+	switch toast.Type {
+	case "success":
+		return m.styles.successToast.Render("[+] " + toast.Message)
+	case "error":
+		return m.styles.errorToast.Render("[!] " + toast.Message)
+	default:
+		return m.styles.infoToast.Render("[i] " + toast.Message)
+	}
+}
+
+// visibleToasts returns the toasts that fit within MaxVisible and the number
+// collapsed into the overflow line.
+func (m Model) visibleToasts() ([]Toast, int) {
+	max := m.toastConfig.MaxVisible
+	if max <= 0 || len(m.toasts) <= max {
+		return m.toasts, 0
+	}
+	return m.toasts[:max], len(m.toasts) - max
+}
+
+// toastPosition returns the x/y of the index-th toast of the given width in
+// content that is contentHeight lines tall. Bottom positions stack upward.
+func (m Model) toastPosition(index, width, contentHeight int) (int, int) {
+	switch m.toastConfig.Position {
+	case "top-right":
+		return m.width - width - 2, 2 + index*2
+	case "bottom-right":
+		return m.width - width - 2, contentHeight - 2 - index*2
+	default: // top-center
+		return (m.width - width) / 2, 2 + index*2
+	}
+}
+
+// ============================================================================
+// Helper Functions
+// ============================================================================
+
+// mainHeight is the height available to the panes between the title and
+// status bars.
+func (m Model) mainHeight() int {
+	return m.height - 4 // Title, status, margins
+}
+
+// messagesWidth is the width of the messages pane in the current layout.
+func (m Model) messagesWidth() int {
+	w, _, _ := m.columnWidths()
+	return w
+}
+
+// minColumnWidth is the narrowest a column may get from the layout ratios.
+const minColumnWidth = 20
+
+// columnWidths splits the terminal width between the messages, editor and
+// MCP columns by layoutRatio. The MCP width is 0 while the panel is hidden.
+// In the vertical layout every pane spans the full width.
+func (m Model) columnWidths() (int, int, int) {
+	if m.layout() == "vertical" {
+		mcp := m.width
+		if !m.showMCP {
+			mcp = 0
+		}
+		return m.width, m.width, mcp
+	}
+
+	ratio := m.layoutRatio
+	if ratio == [3]int{} {
+		ratio = defaultLayoutRatio
+	}
+	if !m.showMCP {
+		ratio[2] = 0
+	}
+
+	total := ratio[0] + ratio[1] + ratio[2]
+	var widths [3]int
+	for i, r := range ratio {
+		widths[i] = m.width * r / total
+	}
+	widths = clampColumns(widths, minColumnWidth)
+	return widths[0], widths[1], widths[2]
+}
+
+// clampColumns widens the visible (non-zero) columns narrower than min,
+// taking the space from the widest column. When there isn't room for every
+// column at min, the widths are left as they are.
+func clampColumns(widths [3]int, min int) [3]int {
+	sum, visible := 0, 0
+	for _, w := range widths {
+		sum += w
+		if w > 0 {
+			visible++
+		}
+	}
+	if sum < min*visible {
+		return widths
+	}
+
+	for i := range widths {
+		if widths[i] == 0 || widths[i] >= min {
+			continue
+		}
+		need := min - widths[i]
+		widest := 0
+		for j := range widths {
+			if widths[j] > widths[widest] {
+				widest = j
+			}
+		}
+		widths[widest] -= need
+		widths[i] = min
+	}
+	return widths
+}
+
+// autoLayoutAspect is the width/height ratio in cells above which the auto
+// layout uses columns; cells are about twice as tall as wide, so 1.6 is a
+// roughly square-looking terminal.
+const autoLayoutAspect = 1.6
+
+// chooseLayout picks the auto layout for a terminal of the given size.
+func chooseLayout(width, height int) string {
+	if height <= 0 || float64(width)/float64(height) > autoLayoutAspect {
+		return "horizontal"
+	}
+	return "vertical"
+}
+
+// layout resolves layoutMode to the arrangement in use, "horizontal" or
+// "vertical".
+func (m Model) layout() string {
+	if m.layoutMode == "auto" || m.layoutMode == "" {
+		return chooseLayout(m.width, m.height)
+	}
+	return m.layoutMode
+}
+
+// verticalRatio is the messages:editor:mcp height split of the vertical
+// layout, and minEditorHeight the fewest rows the editor keeps in it.
+var verticalRatio = [3]int{6, 2, 2}
+
+const minEditorHeight = 5
+
+// rowHeights splits the main height between the messages, editor and MCP
+// panes. Outside the vertical layout every pane gets the whole height; the
+// MCP height is 0 while the panel is hidden.
+func (m Model) rowHeights() (int, int, int) {
+	height := m.mainHeight()
+	if m.layout() != "vertical" {
+		return height, height, height
+	}
+	messages, editor, mcp := splitHeights(height, m.showMCP)
+	return messages - m.outputHeight(), editor, mcp
+}
+
+// outputHeight is the height of the command output pane: part of the
+// editor column, or of the messages row in the vertical layout. It is 0
+// while the pane is hidden.
+func (m Model) outputHeight() int {
+	if !m.showOutput {
+		return 0
+	}
+	if m.layout() == "vertical" {
+		messages, _, _ := splitHeights(m.mainHeight(), m.showMCP)
+		return messages * 2 / 5
+	}
+	return m.mainHeight() * 2 / 5
+}
+
+// splitHeights divides height by verticalRatio, giving the messages pane
+// any rounding remainder.
+func splitHeights(height int, showMCP bool) (int, int, int) {
+	ratio := verticalRatio
+	if !showMCP {
+		ratio[2] = 0
+	}
+	total := ratio[0] + ratio[1] + ratio[2]
+
+	editor := height * ratio[1] / total
+	if editor < minEditorHeight {
+		editor = minEditorHeight
+	}
+	mcp := height * ratio[2] / total
+	messages := height - editor - mcp
+	return messages, editor, mcp
+}
+
+// defaultLayoutRatio is the messages:editor:mcp split of a new model.
+var defaultLayoutRatio = [3]int{4, 4, 2}
+
+// parseLayoutRatio parses a "<messages>:<editor>:<mcp>" ratio such as
+// "4:4:2". Every part must be a whole number from 1 to 10.
+func parseLayoutRatio(s string) ([3]int, error) {
+	var ratio [3]int
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return ratio, fmt.Errorf("layout ratio %q is not m:e:mcp", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 1 || n > 10 {
+			return ratio, fmt.Errorf("layout ratio part %q is not 1-10", part)
+		}
+		ratio[i] = n
+	}
+	return ratio, nil
+}
+
+// visibleMessageLines is the number of message lines that fit in the
+// messages pane (border, padding and title excluded).
+func (m Model) visibleMessageLines() int {
+	if m.plain {
+		return m.plainMessageRows()
+	}
+	h, _, _ := m.rowHeights()
+	return h - 4
+}
+
+// maxScrollOffset is the largest scrollOffset that still fills the pane.
+func (m Model) maxScrollOffset() int {
+	lines := 0
+	if m.plain {
+		lines = len(m.plainMessageLines())
+	} else {
+		lines = len(m.messageLines(m.messagesWidth()))
+	}
+	max := lines - m.visibleMessageLines()
+	if max < 0 {
+		return 0
+	}
+	return max
+}
+
+// pageSize is how far pgup/pgdown move: one viewport, keeping a line of
+// overlap for context.
+func (m Model) pageSize() int {
+	page := m.visibleMessageLines() - 1
+	if page < 1 {
+		return 1
+	}
+	return page
+}
+
+// clock returns the current time from the model's clock.
+func (m Model) clock() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
+// toastDuration looks up how long a toast of the given type stays visible.
+func (m Model) toastDuration(toastType string) time.Duration {
+	if d, ok := m.toastDurations[toastType]; ok {
+		return d
+	}
+	return defaultToastDuration
+}
+
+func (m *Model) addToast(message, toastType string) {
+	if toastType == "error" {
+		m.logger.Error("toast", errorutil.NewError("TOAST_ERROR", message, nil))
+	}
+
+	now := m.clock()
+	toast := Toast{
+		Message:   message,
+		Type:      toastType,
+		CreatedAt: now,
+		ExpiresAt: now.Add(m.toastDuration(toastType)),
+	}
+	m.toasts = append(m.toasts, toast)
+	if m.notifications != nil {
+		m.notifications.Push(toast)
+	}
+}
+
+// inputLen is the length of the editor input in runes.
+func (m Model) inputLen() int {
+	return utf8.RuneCountInString(m.input)
+}
+
+// insertInput inserts text at the cursor and moves the cursor past it.
+func (m *Model) insertInput(text string) {
+	runes := []rune(m.input)
+	inserted := []rune(text)
+	m.input = string(runes[:m.cursor]) + text + string(runes[m.cursor:])
+	m.cursor += len(inserted)
+}
+
+// killRingSize is how many kills the kill ring keeps.
+const killRingSize = 8
+
+// handleEmacsKey applies an emacs editing key to the input: ctrl+t
+// transposes, ctrl+k and ctrl+u kill to the end and start of the input and
+// ctrl+y yanks the last kill.
+func (m *Model) handleEmacsKey(key string) {
+	switch key {
+	case "ctrl+t":
+		m.input, m.cursor = transpose(m.input, m.cursor)
+	case "ctrl+k":
+		runes := []rune(m.input)
+		m.kill(string(runes[m.cursor:]))
+		m.deleteInput(m.cursor, len(runes))
+	case "ctrl+u":
+		m.kill(string([]rune(m.input)[:m.cursor]))
+		m.deleteInput(0, m.cursor)
+		m.cursor = 0
+	case "ctrl+y":
+		if len(m.killRing) > 0 {
+			m.insertInput(m.killRing[len(m.killRing)-1])
+		}
+	}
+}
+
+// kill pushes text onto the kill ring, dropping the oldest kill when full.
+func (m *Model) kill(text string) {
+	if text == "" {
+		return
+	}
+	m.killRing = append(m.killRing, text)
+	if len(m.killRing) > killRingSize {
+		m.killRing = m.killRing[len(m.killRing)-killRingSize:]
+	}
+}
+
+// transpose swaps the grapheme clusters before and at cursor and moves the
+// cursor past both, like emacs. At the end of s it swaps the last two; at
+// the start, or with fewer than two clusters, s is unchanged.
+func transpose(s string, cursor int) (string, int) {
+	bounds := graphemeBounds(s)
+	clusters := len(bounds) - 1
+	if clusters < 2 || cursor == 0 {
+		return s, cursor
+	}
+
+	// Swap cluster i, which ends at the cursor, with the one after it
+	i := 0
+	for i+1 < clusters-1 && bounds[i+1] < cursor {
+		i++
+	}
+
+	runes := []rune(s)
+	a := string(runes[bounds[i]:bounds[i+1]])
+	b := string(runes[bounds[i+1]:bounds[i+2]])
+	out := string(runes[:bounds[i]]) + b + a + string(runes[bounds[i+2]:])
+	return out, bounds[i+2]
+}
+
+// deleteInput removes the runes in [from, to) from the input.
+func (m *Model) deleteInput(from, to int) {
+	runes := []rune(m.input)
+	m.input = string(runes[:from]) + string(runes[to:])
+}
+
+// graphemeBounds returns the rune offsets where each grapheme cluster of s
+// starts, followed by the rune length of s.
+func graphemeBounds(s string) []int {
+	bounds := []int{0}
+	pos, state := 0, -1
+	for s != "" {
+		var cluster string
+		cluster, s, _, state = uniseg.FirstGraphemeClusterInString(s, state)
+		pos += utf8.RuneCountInString(cluster)
+		bounds = append(bounds, pos)
+	}
+	return bounds
+}
+
+// prevGrapheme returns the rune offset of the grapheme cluster boundary
+// before cursor in s.
+func prevGrapheme(s string, cursor int) int {
+	bounds := graphemeBounds(s)
+	for i := len(bounds) - 1; i >= 0; i-- {
+		if bounds[i] < cursor {
+			return bounds[i]
+		}
+	}
+	return 0
+}
+
+// nextGrapheme returns the rune offset of the grapheme cluster boundary
+// after cursor in s, or cursor itself at the end of s.
+func nextGrapheme(s string, cursor int) int {
+	for _, b := range graphemeBounds(s) {
+		if b > cursor {
+			return b
+		}
+	}
+	return cursor
+}
+
+// wrapInput hard-wraps text into rows at most width cells wide, keeping
+// wide runes whole.
+func wrapInput(text string, width int) [][]rune {
+	if width < 1 {
+		width = 1
+	}
+
+	rows := [][]rune{nil}
+	col := 0
+	for _, r := range text {
+		w := lipgloss.Width(string(r))
+		if col+w > width && col > 0 {
+			rows = append(rows, nil)
+			col = 0
+		}
+		rows[len(rows)-1] = append(rows[len(rows)-1], r)
+		col += w
+	}
+	return rows
+}
+
+// cursorPosition returns the visual row and rune column of the rune offset
+// within text as laid out by wrapInput.
+func cursorPosition(text string, offset, width int) (row, col int) {
+	rows := wrapInput(text, width)
+	for i, r := range rows {
+		if offset < len(r) || i == len(rows)-1 {
+			return i, offset
+		}
+		offset -= len(r)
+	}
+	return 0, 0
+}
+
+// cursorGlyph returns the editor cursor for the current style, or a blank
+// cell during the "off" phase of a blink.
+func (m Model) cursorGlyph() string {
+	if m.cursorBlink && (m.frame/cursorBlinkTicks)%2 == 1 {
+		return " "
+	}
+	if glyph, ok := cursorGlyphs[m.cursorStyle]; ok {
+		return glyph
+	}
+	return cursorGlyphs["block"]
+}
+
+// inNormalMode reports whether keys should be interpreted as vim normal-mode
+// commands rather than typed into the editor.
+func (m Model) inNormalMode() bool {
+	return m.vimEnabled && m.editorMode == "normal" && m.activePane == "editor" && !m.showCommand
+}
+
+// handleNormalKey applies a vim normal-mode key to the editor. It reports
+// whether the key was consumed; unhandled keys (ctrl combos, enter, tab)
+// fall through to the regular bindings.
+func (m *Model) handleNormalKey(key string) bool {
+	if m.pendingOp != "" {
+		op := m.pendingOp
+		m.pendingOp = ""
+		if op == "d" && key == "d" {
+			m.input = ""
+			m.cursor = 0
+			return true
+		}
+	}
+
+	switch key {
+	case "h", "left":
+		// Motions and x move over whole graphemes, like the insert-mode keys
+		if m.cursor > 0 {
+			m.cursor = prevGrapheme(m.input, m.cursor)
+		}
+	case "l", "right":
+		if m.cursor < m.inputLen() {
+			m.cursor = nextGrapheme(m.input, m.cursor)
+		}
+	case "j":
+		// The editor is a single line, so j/k scroll the conversation
+		if m.scrollOffset < m.maxScrollOffset() {
+			m.scrollOffset++
+		}
+	case "k":
+		if m.scrollOffset > 0 {
+			m.scrollOffset--
+		}
+	case "i":
+		m.editorMode = "insert"
+	case "a":
+		if m.cursor < m.inputLen() {
+			m.cursor = nextGrapheme(m.input, m.cursor)
+		}
+		m.editorMode = "insert"
+	case "x":
+		if m.cursor < m.inputLen() {
+			m.deleteInput(m.cursor, nextGrapheme(m.input, m.cursor))
+		}
+	case "d":
+		m.pendingOp = "d"
+	default:
+		// Swallow other printable keys so they aren't typed into the input
+		return len([]rune(key)) == 1
+	}
+	return true
+}
+
+// completeCommandPath tab-completes the file path argument of the palette
+// command, if the command takes one.
+func (m *Model) completeCommandPath() {
+	name, arg, found := strings.Cut(m.commandInput, " ")
+	if !found || !pathCommands[strings.ToLower(name)] {
+		return
+	}
+
+	completed, candidates, err := completePath(arg)
+	if err != nil {
+		m.addToast("CANNOT READ DIRECTORY: "+strings.ToUpper(err.Error()), "error")
+		return
+	}
+	m.commandInput = name + " " + completed
+	m.completions = nil
+	if len(candidates) > 1 {
+		m.completions = candidates
+	}
+}
+
+// pushModal opens a modal on top of any already open.
+func (m *Model) pushModal(modal Modal) {
+	m.modals = append(m.modals, modal)
+}
+
+// popModal closes the top modal.
+func (m *Model) popModal() {
+	if len(m.modals) > 0 {
+		m.modals = m.modals[:len(m.modals)-1]
+	}
+}
+
+// topModal returns the modal that currently has focus, or nil.
+func (m Model) topModal() Modal {
+	if len(m.modals) == 0 {
+		return nil
+	}
+	return m.modals[len(m.modals)-1]
+}
+
+// updateModal routes a key to the top modal instead of the panes. Escape
+// always closes the modal; ctrl+c still quits.
+func (m Model) updateModal(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc":
+		m.popModal()
+		return m, nil
+	}
+
+	modal, cmd, done := m.topModal().Update(msg)
+	m.modals = append(m.modals[:len(m.modals)-1:len(m.modals)-1], modal)
+	if done {
+		m.popModal()
+	}
+	return m, cmd
+}
+
+// toggleAltScreen flips between the alternate screen and the normal
+// terminal buffer, where text can be selected natively.
+func (m *Model) toggleAltScreen() tea.Cmd {
+	m.altScreen = !m.altScreen
+	if m.altScreen {
+		m.addToast("ALT SCREEN: ON", "info")
+		return tea.EnterAltScreen
+	}
+	m.addToast("ALT SCREEN: OFF", "info")
+	return tea.ExitAltScreen
+}
+
+// nextMessageID returns an ID not used by any current message.
+func (m Model) nextMessageID() int {
+	id := 0
+	for _, msg := range m.messages {
+		if msg.ID > id {
+			id = msg.ID
+		}
+	}
+	return id + 1
+}
+
+// messageIndex returns the index of the message with the given ID, or -1.
+func (m Model) messageIndex(id int) int {
+	for i, msg := range m.messages {
+		if msg.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// opIndex returns the index of the MCP operation answering the message with
+// the given ID, or -1 if there is none.
+func (m Model) opIndex(messageID int) int {
+	for i := len(m.mcpOps) - 1; i >= 0; i-- {
+		if m.mcpOps[i].MessageID == messageID {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolveMessage parses a message ID as typed in a command, "#5" or "5",
+// and returns the index of that message.
+func (m Model) resolveMessage(arg string) (int, error) {
+	id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err != nil {
+		return -1, fmt.Errorf("bad message id %q", arg)
+	}
+	i := m.messageIndex(id)
+	if i < 0 {
+		return -1, fmt.Errorf("no message #%d", id)
+	}
+	return i, nil
+}
+
+// deleteMessage removes the message at index i. System messages are
+// protected, and nothing is removed while a response is pending since the
+// reply is matched to its request by ID.
+func (m *Model) deleteMessage(i int) error {
+	if m.messages[i].Role == "system" {
+		return errors.New("system messages can't be deleted")
+	}
+	if m.isProcessing {
+		return errors.New("wait for the current response")
+	}
+	m.messages = append(m.messages[:i], m.messages[i+1:]...)
+	m.clearSelection()
+	if max := m.maxScrollOffset(); m.scrollOffset > max {
+		m.scrollOffset = max
+	}
+	return nil
+}
+
+// messageOffset is the line the message at index i starts on in the
+// messages pane, counting wrapped lines and the gaps between messages.
+func (m Model) messageOffset(i int) int {
+	offset := 0
+	if m.plain {
+		for _, msg := range m.messages[:i] {
+			offset += len(m.plainMessage(msg)) + m.spacing
+		}
+		return offset
+	}
+	width := m.messageColumnWidth(m.messagesWidth())
+	for j, msg := range m.messages[:i] {
+		selected := m.isSelected(j)
+		lines, ok := m.lineCache.get(width, msg, selected)
+		if !ok {
+			lines = m.renderMessage(msg, width, selected)
+		}
+		offset += len(lines) + m.spacing
+	}
+	return offset
+}
+
+// scrollToMessage selects the message at index i and scrolls it to the top
+// of the pane, or as close as the end of the conversation allows.
+func (m *Model) scrollToMessage(i int) {
+	m.selStart, m.selEnd = i, i
+	m.scrollOffset = m.messageOffset(i)
+	if max := m.maxScrollOffset(); m.scrollOffset > max {
+		m.scrollOffset = max
+	}
+}
+
+// toggleIDs shows or hides the #ID prefix. The line cache is keyed by
+// message and width only, so it has to be flushed.
+func (m *Model) toggleIDs() {
+	m.showIDs = !m.showIDs
+	m.lineCache.invalidate()
+	if m.showIDs {
+		m.addToast("MESSAGE IDS: ON", "info")
+	} else {
+		m.addToast("MESSAGE IDS: OFF", "info")
+	}
+}
+
+// appendMessage adds a message to the conversation and wraps it into the
+// line cache once, so rendering only concatenates cached lines instead of
+// re-wrapping the whole conversation. Long assistant replies start collapsed,
+// and the pane auto-scrolls to them if it was at the bottom.
+func (m *Model) appendMessage(msg Message) {
+	if msg.Role == "assistant" {
+		msg.Collapsed = m.isLongMessage(msg.Content)
+	}
+	// Follow new messages only while the view is at the bottom
+	if m.scrollOffset >= m.maxScrollOffset() {
+		m.autoScroll.Request()
+	}
+	m.messages = append(m.messages, msg)
+	if m.width > 0 {
+		width := m.messageColumnWidth(m.messagesWidth())
+		m.lineCache.put(width, msg, false, m.renderMessage(msg, width, false))
+	}
+}
+
+// sendInput appends the editor input as a user message and requests a
+// response to it.
+func (m *Model) sendInput() tea.Cmd {
+	if m.sendLimit != nil && !m.sendLimit.AllowAt(m.clock()) {
+		// The input stays in the editor to be sent again
+		m.addToast("SLOW DOWN", "error")
+		return nil
+	}
+	m.appendMessage(Message{
+		ID:        m.nextMessageID(),
+		Content:   m.input,
+		Role:      "user",
+		Timestamp: time.Now(),
+	})
+
+	cmd := m.requestResponse(len(m.messages) - 1)
+	m.input = ""
+	m.cursor = 0
+	m.contextTokens += rand.Intn(100) + 50
+	m.cost += float64(rand.Intn(10)) / 100
+
+	return cmd
+}
+
+// requestResponse sends the user message at index to the provider and
+// tracks the request as an MCP operation.
+func (m *Model) requestResponse(index int) tea.Cmd {
+	msg := m.messages[index]
+	m.mcpOps = append(m.mcpOps, MCPOperation{
+		ID:        fmt.Sprintf("OP-%03d", len(m.mcpOps)+1),
+		Tool:      "processing",
+		Status:    "running",
+		Progress:  0,
+		MessageID: msg.ID,
+	})
+
+	m.isProcessing = true
+	m.latency.Start(msg.ID, m.clock())
+	ctx := m.requestContext(strconv.Itoa(msg.ID))
+	input := withSystemPrompt(m.systemPrompt, msg.Content)
+
+	policy, updates := m.retry, m.updates
+	policy.OnRetry = func(attempt int, err error) {
+		post(ctx, updates, RetryingMsg{messageID: msg.ID, attempt: attempt, attempts: policy.MaxAttempts})
+	}
+	if sp, ok := m.provider.(StreamingProvider); ok {
+		m.stream = startStream(ctx, sp, msg.ID, input, m.timeout, policy)
+		return waitForStream(m.stream)
+	}
+	return processCommand(ctx, m.provider, msg.ID, input, m.timeout, policy)
+}
+
+// requestContext is the context a provider call runs in. It carries the
+// session ID, a request ID made from the session and request, and a fresh
+// trace ID, so provider logs correlate with the TUI's.
+func (m Model) requestContext(request string) context.Context {
+	ctx := syncutil.WithValue(context.Background(), syncutil.SessionIDKey, m.sessionID)
+	ctx = syncutil.WithValue(ctx, syncutil.RequestIDKey, m.sessionID+"/"+request)
+	return syncutil.WithValue(ctx, syncutil.TraceIDKey, fmt.Sprintf("%016x", rand.Uint64()))
+}
+
+// selectMessage moves the focused message by delta, starting from the
+// newest message when nothing is selected. With extend the anchor stays put
+// so the selection grows into a range.
+func (m *Model) selectMessage(delta int, extend bool) {
+	if len(m.messages) == 0 {
+		return
+	}
+	if m.selectedIndex() < 0 {
+		m.selEnd = len(m.messages)
+		m.selStart = len(m.messages) - 1
+	}
+	m.selEnd += delta
+	if m.selEnd < 0 {
+		m.selEnd = 0
+	} else if m.selEnd >= len(m.messages) {
+		m.selEnd = len(m.messages) - 1
+	}
+	if !extend || m.selStart < 0 || m.selStart >= len(m.messages) {
+		m.selStart = m.selEnd
+	}
+}
+
+// clearSelection deselects all messages.
+func (m *Model) clearSelection() {
+	m.selStart, m.selEnd = -1, -1
+}
+
+// selectedIndex returns the index of the focused selected message, or -1.
+func (m Model) selectedIndex() int {
+	if m.selEnd < 0 || m.selEnd >= len(m.messages) {
+		return -1
+	}
+	return m.selEnd
+}
+
+// selectionRange returns the inclusive bounds of the selected messages.
+func (m Model) selectionRange() (int, int, bool) {
+	if m.selectedIndex() < 0 {
+		return 0, 0, false
+	}
+	lo, hi := m.selStart, m.selEnd
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	if lo < 0 {
+		lo = 0
+	}
+	return lo, hi, true
+}
+
+// isSelected reports whether the message at index i is in the selection.
+func (m Model) isSelected(i int) bool {
+	lo, hi, ok := m.selectionRange()
+	return ok && i >= lo && i <= hi
+}
+
+// copySelection copies the selected messages to the clipboard as a
+// plain-text transcript.
+func (m *Model) copySelection() tea.Cmd {
+	lo, hi, ok := m.selectionRange()
+	if !ok {
+		return nil
+	}
+
+	text := formatTranscript(m.messages[lo : hi+1])
+	m.addToast(fmt.Sprintf("COPIED %d MESSAGE(S)", hi-lo+1), "success")
+	return copyToClipboard(text)
+}
+
+// copyCode copies the first code block of the focused message.
+func (m *Model) copyCode() tea.Cmd {
+	blocks := extractCodeBlocks(m.messages[m.selectedIndex()].Content)
+	if len(blocks) == 0 {
+		m.addToast("NO CODE BLOCK IN MESSAGE", "error")
+		return nil
+	}
+
+	toast := "COPIED CODE"
+	if blocks[0].Lang != "" {
+		toast = "COPIED " + strings.ToUpper(blocks[0].Lang) + " CODE"
+	}
+	m.addToast(toast, "success")
+	return copyToClipboard(blocks[0].Code)
+}
+
+// copyToClipboard copies text to the system clipboard with an OSC 52
+// escape, which works over SSH in most modern terminals.
+func copyToClipboard(text string) tea.Cmd {
+	return func() tea.Msg {
+		termenv.Copy(text)
+		return nil
+	}
+}
+
+// formatTranscript renders messages as the plain-text transcript used for
+// copying and .txt exports.
+func formatTranscript(messages []Message) string {
+	var b strings.Builder
+	for _, msg := range messages {
+		role := strings.ToUpper(msg.Role)
+		if msg.Tool != "" {
+			role += "[" + msg.Tool + "]"
+		}
+		fmt.Fprintf(&b, "[%s] %s: %s\n", msg.Timestamp.Format("2006-01-02 15:04:05"), role, msg.Content)
+	}
+	return b.String()
+}
+
+// formatMarkdown renders messages as a Markdown transcript for .md exports.
+func formatMarkdown(messages []Message) string {
+	var b strings.Builder
+	for _, msg := range messages {
+		role := strings.ToUpper(msg.Role)
+		if msg.Tool != "" {
+			role += " (" + msg.Tool + ")"
+		}
+		fmt.Fprintf(&b, "### %s · %s\n\n%s\n\n", role, msg.Timestamp.Format("2006-01-02 15:04:05"), msg.Content)
+	}
+	return b.String()
+}
+
+// defaultRedactPatterns match common API key and token formats.
+var defaultRedactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`sk-[A-Za-z0-9_-]{20,}`),                             // OpenAI and Anthropic keys
+	regexp.MustCompile(`gh[pousr]_[A-Za-z0-9]{36,}`),                        // GitHub tokens
+	regexp.MustCompile(`AKIA[0-9A-Z]{16}`),                                  // AWS access key IDs
+	regexp.MustCompile(`xox[abprs]-[A-Za-z0-9-]{10,}`),                      // Slack tokens
+	regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`), // JWTs
+	regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._~+/-]{16,}=*`),
+}
+
+// redact replaces every match of patterns in content with "****".
+func redact(content string, patterns []*regexp.Regexp) string {
+	for _, re := range patterns {
+		content = re.ReplaceAllLiteralString(content, "****")
+	}
+	return content
+}
+
+// exportTranscript writes the conversation to path with secrets redacted,
+// as JSON, Markdown or plain text by the file's extension.
+func (m Model) exportTranscript(path string) error {
+	messages := make([]Message, len(m.messages))
+	for i, msg := range m.messages {
+		msg.Content = redact(msg.Content, m.redactPatterns)
+		msg.Error = redact(msg.Error, m.redactPatterns)
+		messages[i] = msg
+	}
+
+	var data []byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		sf := m.snapshot()
+		sf.Messages = messages
+		sf.Notes = redact(sf.Notes, m.redactPatterns)
+		var err error
+		if data, err = json.MarshalIndent(sf, "", "  "); err != nil {
+			return err
+		}
+	case ".md":
+		data = []byte(formatMarkdown(messages))
+	default:
+		data = []byte(formatTranscript(messages))
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// handleMessagesKey applies the actions on selected messages available
+// while the messages pane is focused. It reports whether the key was used;
+// without a selection the keys fall through to the editor as typing.
+func (m *Model) handleMessagesKey(key string) (tea.Cmd, bool) {
+	if m.selectedIndex() < 0 {
+		return nil, false
+	}
+	switch key {
+	case "r":
+		return m.retrySelected(), true
+	case "c":
+		return m.copySelection(), true
+	case "y":
+		return m.copyCode(), true
+	case "enter", " ":
+		m.toggleCollapsed()
+		return nil, true
+	case "a":
+		m.cycleReaction()
+		return nil, true
+	}
+	return nil, false
+}
+
+// cycleReaction moves the selected message to its next reaction.
+func (m *Model) cycleReaction() {
+	msg := &m.messages[m.selectedIndex()]
+	msg.Reaction = nextReaction(msg.Reaction)
+}
+
+// annotatedMessages returns the messages that carry a reaction, in order.
+func annotatedMessages(messages []Message) []Message {
+	var annotated []Message
+	for _, msg := range messages {
+		if msg.Reaction != "" {
+			annotated = append(annotated, msg)
+		}
+	}
+	return annotated
+}
+
+// toggleCollapsed expands or collapses the selected message if it is long.
+func (m *Model) toggleCollapsed() {
+	i := m.selectedIndex()
+	msg := &m.messages[i]
+	if !msg.Collapsed && !m.isLongMessage(msg.Content) {
+		return
+	}
+	msg.Collapsed = !msg.Collapsed
+	if max := m.maxScrollOffset(); m.scrollOffset > max {
+		m.scrollOffset = max
+	}
+}
+
+// replaceLiteral replaces every occurrence of find in s with replace and
+// reports how many were made. find is matched literally and must not be
+// empty.
+func replaceLiteral(s, find, replace string) (string, int, error) {
+	if find == "" {
+		return s, 0, fmt.Errorf("find string is empty")
+	}
+	n := strings.Count(s, find)
+	return strings.ReplaceAll(s, find, replace), n, nil
+}
+
+// canAcceptInput reports whether the editor may take input or send: no
+// response is pending and no modal or palette has the keyboard.
+func (m Model) canAcceptInput() bool {
+	return !m.isProcessing && len(m.modals) == 0 && !m.showCommand
+}
+
+// isPrintableKey reports whether msg types text rather than navigating or
+// triggering a binding.
+func isPrintableKey(msg tea.KeyMsg) bool {
+	return (msg.Type == tea.KeyRunes || msg.Type == tea.KeySpace) && !msg.Alt
+}
+
+// retrySelected re-sends the selected message if its response failed.
+func (m *Model) retrySelected() tea.Cmd {
+	i := m.selectedIndex()
+	if i < 0 || !m.canAcceptInput() || !m.messages[i].Failed {
+		return nil
+	}
+
+	m.messages[i].Failed = false
+	m.messages[i].Error = ""
+	m.addToast("RETRYING", "info")
+	return m.requestResponse(i)
+}
+
+// dismissToast removes the oldest active toast.
+func (m *Model) dismissToast() {
+	if len(m.toasts) > 0 {
+		m.toasts = m.toasts[1:]
+	}
+}
+
+// finishResponse records the provider's answer to a message: the reply is
+// appended, or fills the streamed reply message, and a failure marks the
+// message for retry.
+func (m *Model) finishResponse(msg ProcessingDoneMsg) tea.Cmd {
+	m.isProcessing = false
+	m.latency.Finish(msg.messageID, m.clock(), msg.err == nil)
+
+	op := m.opIndex(msg.messageID)
+	if msg.err != nil {
+		m.logger.Error("provider", msg.err)
+		if op >= 0 {
+			m.mcpOps[op].Status = "failed"
+		}
+		if i := m.messageIndex(msg.messageID); i >= 0 {
+			m.messages[i].Failed = true
+			m.messages[i].Error = msg.err.Error()
+		}
+		if errorutil.Is(msg.err, errorutil.ErrTimeout) {
+			m.addToast(fmt.Sprintf("RESPONSE TIMED OUT AFTER %s", m.timeout), "error")
+		} else {
+			m.addToast("PROCESSING FAILED: "+strings.ToUpper(msg.err.Error()), "error")
+		}
+		if m.bell {
+			m.ringing = true
+			return bellCmd()
+		}
+		return nil
+	}
+
+	// Update MCP operation
+	if op >= 0 {
+		m.mcpOps[op].Status = "completed"
+		m.mcpOps[op].Tool = msg.tool
+	}
+
+	if i := m.messageIndex(msg.replyID); msg.replyID != 0 && i >= 0 {
+		reply := &m.messages[i]
+		reply.Content = msg.response
+		reply.Tool = msg.tool
+		reply.Collapsed = m.isLongMessage(reply.Content)
+	} else {
+		// Add response
+		m.appendMessage(Message{
+			ID:        m.nextMessageID(),
+			Content:   msg.response,
+			Role:      "assistant",
+			Timestamp: time.Now(),
+			Tool:      msg.tool,
+		})
+	}
+
+	m.addToast("PROCESSING COMPLETE", "success")
+	return nil
+}
+
+// interruptStream stops the reply being streamed, keeping what has arrived
+// as the assistant message marked "[interrupted]".
+func (m *Model) interruptStream() {
+	st := m.stream
+	if st == nil {
+		return
+	}
+	st.cancel()
+	m.stream = nil
+	m.isProcessing = false
+	m.latency.Finish(st.messageID, m.clock(), false)
+	if op := m.opIndex(st.messageID); op >= 0 {
+		m.mcpOps[op].Status = "cancelled"
+	}
+
+	content := strings.TrimSpace(strings.TrimSpace(st.partial) + " [interrupted]")
+	if i := m.messageIndex(st.replyID); st.replyID != 0 && i >= 0 {
+		m.messages[i].Content = content
+	} else {
+		m.appendMessage(Message{
+			ID:        m.nextMessageID(),
+			Content:   content,
+			Role:      "assistant",
+			Timestamp: time.Now(),
+		})
+	}
+	m.addToast("RESPONSE INTERRUPTED", "info")
+}
+
+// setModel switches the provider that answers new messages. It refuses
+// while a response is pending, so every reply comes from the provider
+// that was asked.
+func (m *Model) setModel(name string) error {
+	name = strings.ToLower(name)
+	provider, ok := providers[name]
+	if !ok {
+		return fmt.Errorf("unknown model: %s", name)
+	}
+	if m.isProcessing {
+		return errors.New("wait for the current response")
+	}
+	m.modelName, m.provider = name, provider
+	m.addToast("MODEL: "+strings.ToUpper(name), "info")
+	return nil
+}
+
+// toggleOutput shows or hides the command output pane.
+func (m *Model) toggleOutput() {
+	m.showOutput = !m.showOutput
+	if !m.showOutput && m.activePane == "output" {
+		m.activePane = "editor"
+	}
+	if max := m.maxScrollOffset(); m.scrollOffset > max {
+		m.scrollOffset = max
+	}
+}
+
+// report shows the result of a command: in the output pane when it is
+// open, so it persists, or else in a modal.
+func (m *Model) report(title string, lines []string) {
+	if m.showOutput {
+		m.output.Append("$ " + strings.ToLower(title) + "\n" + strings.Join(lines, "\n"))
+		return
+	}
+	m.pushModal(textModal{title: title, lines: lines})
+}
+
+// setQuiet turns every effect off, remembering the previous switches, or
+// restores them. It returns the commands that restart restored effects.
+func (m *Model) setQuiet(on bool) tea.Cmd {
+	if on == m.quiet {
+		return nil
+	}
+	m.quiet = on
+
+	if on {
+		m.savedEffects = effects{
+			glitch:      m.glitchEffect,
+			scanlines:   m.scanlines,
+			cursorBlink: m.cursorBlink,
+			bell:        m.bell,
+			rain:        m.rain != nil,
+		}
+		m.glitchEffect, m.scanlines, m.cursorBlink, m.bell = false, false, false, false
+		m.rain = nil
+		return nil
+	}
+
+	e := m.savedEffects
+	m.glitchEffect, m.scanlines, m.cursorBlink, m.bell = e.glitch, e.scanlines, e.cursorBlink, e.bell
+	if e.rain {
+		m.rain = &matrixRain{}
+	}
+
+	var cmds []tea.Cmd
+	if m.glitchEffect {
+		cmds = append(cmds, glitchCmd(m.timing().glitch))
+	}
+	if m.scanlines {
+		cmds = append(cmds, scanlineCmd(m.timing().scanline))
+	}
+	return tea.Batch(cmds...)
+}
+
+// setTheme switches to the named built-in theme.
+func (m *Model) setTheme(name string) error {
+	t, ok := themes[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown theme %q", name)
+	}
+	m.useTheme(t)
+	return nil
+}
+
+// useTheme applies t and redraws with it.
+func (m *Model) useTheme(t Theme) {
+	m.theme = t
+	m.styles = newStyles(t, m.profile)
+	m.lineCache.invalidate()
+	m.frames.invalidate()
+}
+
+// formatTimestamp formats t as a message clock time, in UTC (marked with a
+// trailing Z) or in the local zone.
+func formatTimestamp(t time.Time, utc bool) string {
+	if utc {
+		return t.UTC().Format("15:04:05") + "Z"
+	}
+	return t.Local().Format("15:04:05")
+}
+
+// defaultSessionsDir is where sessions are saved when no path is given.
+func defaultSessionsDir() string {
+	return expandHome("~/.retro-dgmo/sessions")
+}
+
+// sessionNamePattern limits session names to what is safe in a file name.
+var sessionNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// validateSessionName rejects names that aren't safe as a session file
+// name, such as ones that would climb out of the sessions directory.
+func validateSessionName(name string) error {
+	if !sessionNamePattern.MatchString(name) {
+		return errors.New("session name may only use letters, digits, - and _")
+	}
+	return nil
+}
+
+// sessionPath is the default save location of the current session.
+func (m Model) sessionPath() string {
+	return filepath.Join(m.sessionsDir, m.sessionID+".json")
+}
+
+// snapshot captures the session's messages and stats. The slices are
+// copied, so the snapshot is independent of later changes to m.
+func (m Model) snapshot() sessionFile {
+	return sessionFile{
+		ID:            m.sessionID,
+		Messages:      append([]Message(nil), m.messages...),
+		MCPOps:        append([]MCPOperation(nil), m.mcpOps...),
+		ContextTokens: m.contextTokens,
+		Cost:          m.cost,
+		Notes:         m.notes,
+		SavedAt:       m.clock(),
+	}
+}
+
+// saveSession writes the session's messages and stats to path as JSON.
+func (m Model) saveSession(path string) error {
+	data, err := json.MarshalIndent(m.snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// loadSession reads a session saved by saveSession.
+func loadSession(path string) (sessionFile, error) {
+	var sf sessionFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sf, err
+	}
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return sf, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return sf, nil
+}
+
+// applySession replaces the conversation and stats with a loaded session.
+func (m *Model) applySession(sf sessionFile) {
+	m.sessionID = sf.ID
+	m.messages = sf.Messages
+	m.mcpOps = sf.MCPOps
+	m.contextTokens = sf.ContextTokens
+	m.cost = sf.Cost
+	m.notes = sf.Notes
+	m.scrollOffset = 0
+	m.clearSelection()
+}
+
+// stateDump is the model state written by the "dumpstate" command for bug
+// reports. Functions, channels and caches are left out.
+type stateDump struct {
+	DumpedAt time.Time      `json:"dumped_at"`
+	Width    int            `json:"width"`
+	Height   int            `json:"height"`
+	Session  sessionDump    `json:"session"`
+	Messages []Message      `json:"messages"`
+	MCPOps   []MCPOperation `json:"mcp_ops"`
+	Toasts   []Toast        `json:"toasts"`
+	Flags    flagsDump      `json:"flags"`
+	Editor   editorDump     `json:"editor"`
+}
+
+type sessionDump struct {
+	ID            string  `json:"id"`
+	Model         string  `json:"model"`
+	Theme         string  `json:"theme"`
+	ContextTokens int     `json:"context_tokens"`
+	Cost          float64 `json:"cost"`
+	Dir           string  `json:"dir"`
+	Notes         string  `json:"notes,omitempty"`
+}
+
+type flagsDump struct {
+	ActivePane   string `json:"active_pane"`
+	Layout       string `json:"layout"`
+	LayoutRatio  [3]int `json:"layout_ratio"`
+	ShowMCP      bool   `json:"show_mcp"`
+	ShowOutput   bool   `json:"show_output"`
+	ShowCommand  bool   `json:"show_command"`
+	Modals       int    `json:"modals"`
+	IsProcessing bool   `json:"is_processing"`
+	Streaming    bool   `json:"streaming"`
+	Tailing      string `json:"tailing,omitempty"`
+	NoColor      bool   `json:"no_color"`
+	Quiet        bool   `json:"quiet"`
+	Glitch       bool   `json:"glitch"`
+	Scanlines    bool   `json:"scanlines"`
+	CursorBlink  bool   `json:"cursor_blink"`
+	Bell         bool   `json:"bell"`
+	DisplayUTC   bool   `json:"display_utc"`
+	AltScreen    bool   `json:"alt_screen"`
+}
+
+type editorDump struct {
+	Input        string `json:"input"`
+	Cursor       int    `json:"cursor"`
+	Prompt       string `json:"prompt"`
+	Vim          bool   `json:"vim"`
+	Mode         string `json:"mode,omitempty"`
+	Emacs        bool   `json:"emacs"`
+	ScrollOffset int    `json:"scroll_offset"`
+	SelStart     int    `json:"sel_start"`
+	SelEnd       int    `json:"sel_end"`
+}
+
+// dump captures the model's state for dumpState.
+func (m Model) dump() stateDump {
+	tailing := ""
+	if m.tail != nil {
+		tailing = m.tail.path
+	}
+	return stateDump{
+		DumpedAt: m.clock(),
+		Width:    m.width,
+		Height:   m.height,
+		Session: sessionDump{
+			ID:            m.sessionID,
+			Model:         m.modelName,
+			Theme:         m.theme.Name,
+			ContextTokens: m.contextTokens,
+			Cost:          m.cost,
+			Dir:           m.sessionsDir,
+			Notes:         m.notes,
+		},
+		Messages: m.messages,
+		MCPOps:   m.mcpOps,
+		Toasts:   m.toasts,
+		Flags: flagsDump{
+			ActivePane:   m.activePane,
+			Layout:       m.layout(),
+			LayoutRatio:  m.layoutRatio,
+			ShowMCP:      m.showMCP,
+			ShowOutput:   m.showOutput,
+			ShowCommand:  m.showCommand,
+			Modals:       len(m.modals),
+			IsProcessing: m.isProcessing,
+			Streaming:    m.stream != nil,
+			Tailing:      tailing,
+			NoColor:      m.noColor,
+			Quiet:        m.quiet,
+			Glitch:       m.glitchEffect,
+			Scanlines:    m.scanlines,
+			CursorBlink:  m.cursorBlink,
+			Bell:         m.bell,
+			DisplayUTC:   m.displayUTC,
+			AltScreen:    m.altScreen,
+		},
+		Editor: editorDump{
+			Input:        m.input,
+			Cursor:       m.cursor,
+			Prompt:       m.prompt,
+			Vim:          m.vimEnabled,
+			Mode:         m.editorMode,
+			Emacs:        m.emacsEnabled,
+			ScrollOffset: m.scrollOffset,
+			SelStart:     m.selStart,
+			SelEnd:       m.selEnd,
+		},
+	}
+}
+
+// dumpState writes the model's state to path as indented JSON.
+func (m Model) dumpState(path string) error {
+	data, err := json.MarshalIndent(m.dump(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// duplicateSession saves the current session and switches to a copy of it
+// under a new ID: name if given, otherwise a fresh generated one.
+func (m *Model) duplicateSession(name string) error {
+	id := newSessionID(m.clock())
+	if name != "" {
+		if err := validateSessionName(name); err != nil {
+			return err
+		}
+		id = strings.ToUpper(name)
+	}
+	if id == m.sessionID {
+		// Generated IDs have one-second resolution
+		id += "-COPY"
+	}
+	if err := m.saveSession(m.sessionPath()); err != nil {
+		return err
+	}
+
+	sf := m.snapshot()
+	sf.ID = id
+	m.applySession(sf)
+	return nil
+}
+
+// grepTimeout bounds a search across saved sessions.
+const grepTimeout = 5 * time.Second
+
+// maxGrepMatches caps how many matches a session search returns.
+const maxGrepMatches = 200
+
+// sessionMatch is one line of a saved message matching a grep pattern.
+type sessionMatch struct {
+	SessionID string
+	Path      string
+	MessageID int
+	Line      string
+}
+
+// grepSessionsCmd searches the sessions in dir for pattern in the
+// background.
+func grepSessionsCmd(dir, pattern string, re *regexp.Regexp) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), grepTimeout)
+		defer cancel()
+		matches, err := searchSessions(ctx, dir, re)
+		return GrepDoneMsg{pattern: pattern, matches: matches, err: err}
+	}
+}
+
+// searchSessions returns the message lines matching re in every session
+// saved in dir, newest file name last. It stops with ctx's error when ctx
+// is done, and skips files that aren't sessions.
+func searchSessions(ctx context.Context, dir string, re *regexp.Regexp) ([]sessionMatch, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var matches []sessionMatch
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return matches, err
+		}
+		sf, err := loadSession(path)
+		if err != nil {
+			continue
+		}
+		for _, msg := range sf.Messages {
+			for _, line := range strings.Split(msg.Content, "\n") {
+				if !re.MatchString(line) {
+					continue
+				}
+				matches = append(matches, sessionMatch{
+					SessionID: sf.ID,
+					Path:      path,
+					MessageID: msg.ID,
+					Line:      strings.TrimSpace(line),
+				})
+				if len(matches) >= maxGrepMatches {
+					return matches, nil
+				}
+			}
+		}
+	}
+	return matches, nil
+}
+
+// startNewSession replaces the conversation with a fresh session, saving
+// the old one first when autoSave is on.
+func (m *Model) startNewSession() {
+	if m.autoSave {
+		if err := m.saveSession(m.sessionPath()); err != nil {
+			m.addToast("AUTO-SAVE FAILED: "+err.Error(), "error")
+		}
+	}
+
+	now := m.clock()
+	m.sessionID = newSessionID(now)
+	m.messages = greetingMessages(now, m.greeting, m.systemPrompt)
+	m.mcpOps = nil
+	m.contextTokens = 0
+	m.cost = 0
+	m.notes = ""
+	m.scrollOffset = 0
+	m.addToast("NEW SESSION: "+m.sessionID, "success")
+}
+
+// CommandHandler runs a palette command with the arguments typed after its
+// name, original case preserved, and returns any command it starts. A
+// non-nil error means the command failed; the palette shows it as an error
+// toast.
+type CommandHandler func(m *Model, args []string) (tea.Cmd, error)
+
+// RawCommandHandler is a CommandHandler that gets the text typed after the
+// command name exactly as typed, spacing included.
+type RawCommandHandler func(m *Model, arg string) (tea.Cmd, error)
+
+// paletteCommand is a registered palette command. Commands with a raw
+// handler get their argument text instead of split arguments.
+type paletteCommand struct {
+	name        string
+	description string
+	handler     CommandHandler
+	raw         RawCommandHandler
+}
+
+// commands holds the palette commands by lowercase name.
+var commands = map[string]paletteCommand{}
+
+func init() {
+	builtins := []struct {
+		name, description string
+		handler           CommandHandler
+	}{
+		{"theme", "theme classic|amber|phosphor|load <path>|save <path> - switch, load or save the color theme", cmdTheme},
+		{"tz", "tz local|utc - timestamp time zone", cmdTZ},
+		{"quiet", "quiet - toggle all effects off", cmdQuiet},
+		{"matrix", "matrix - toggle falling-character rain behind the panes", cmdMatrix},
+		{"clear", "clear - remove the conversation", cmdClear},
+		{"cursor", "cursor block|bar|underline|blink - cursor style", cmdCursor},
+		{"vim", "vim - toggle vim keys in the editor", cmdVim},
+		{"emacs", "emacs - toggle ctrl+k kill-line in the editor", cmdEmacs},
+		{"help", "help - key bindings and commands", cmdHelp},
+		{"new", "new - start a new session", cmdNew},
+		{"session", "session <id> - rename the session", cmdSession},
+		{"save", "save [path] - save the session", cmdSave},
+		{"export", "export <path> - write the transcript as .txt, .md or .json, secrets redacted", cmdExport},
+		{"screensaver", "screensaver <idle>|off [logo|clock] - show a screensaver when idle", cmdScreensaver},
+		{"source", "source <path> - run the palette commands in a file", cmdSource},
+		{"dumpstate", "dumpstate <path> - write the UI state as JSON for a bug report", cmdDumpState},
+		{"dup", "dup [name] - save the session and continue in a copy", cmdDup},
+		{"notes", "notes - edit the session scratchpad", cmdNotes},
+		{"stats", "stats - token and cost totals", cmdStats},
+		{"toastpos", "toastpos top-center|top-right|bottom-right - where toasts appear", cmdToastPos},
+		{"width", "width <n> - wrap message text at n columns, 0 for the full pane", cmdWidth},
+		{"reactions", "reactions - list messages with a reaction", cmdReactions},
+		{"notifications", "notifications - past toasts with their times", cmdNotifications},
+		{"ids", "ids - toggle showing message IDs", cmdIDs},
+		{"delete", "delete <id> - delete a message", cmdDelete},
+		{"pin", "pin <id> - pin or unpin a message", cmdPin},
+		{"copy", "copy <id> - copy a message to the clipboard", cmdCopy},
+		{"goto", "goto <id>|first|last - scroll a message to the top of the pane", cmdGoto},
+		{"perf", "perf - update and render timings", cmdPerf},
+		{"output", "output - toggle the command output pane", cmdOutput},
+		{"summarize", "summarize [n] - summarize the last n exchanges", cmdSummarize},
+		{"tail", "tail <path>|stop - follow a file into the conversation", cmdTail},
+		{"grep", "grep <pattern> - search saved sessions", cmdGrep},
+		{"model", "model [name] - switch the response provider", cmdModel},
+		{"ping", "ping - check that the response provider is reachable", cmdPing},
+		{"scrolldelay", "scrolldelay <ms> - auto-scroll debounce delay", cmdScrollDelay},
+		{"spacing", "spacing <n> - blank lines between messages", cmdSpacing},
+		{"fps", fmt.Sprintf("fps <n> - animation frame rate, %d-%d", minFPS, maxFPS), cmdFPS},
+		{"toasts", "toasts <n> - show at most n toasts, 0 for all", cmdToasts},
+		{"layout", "layout <m>:<e>:<mcp>|auto|vertical|horizontal - pane arrangement", cmdLayout},
+	}
+	for _, c := range builtins {
+		commands[c.name] = paletteCommand{name: c.name, description: c.description, handler: c.handler}
+	}
+	commands["prompt"] = paletteCommand{
+		name:        "prompt",
+		description: "prompt [text] - set the editor prompt; {mode}, {model} and {session} expand",
+		raw:         cmdPrompt,
+	}
+}
+
+// RegisterCommand adds a palette command. It is meant to be called at
+// startup, before the program runs, and fails if the name is empty,
+// contains spaces or is already taken by a built-in or another command.
+func RegisterCommand(name, description string, handler CommandHandler) error {
+	name = strings.ToLower(name)
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("invalid command name %q", name)
+	}
+	if handler == nil {
+		return fmt.Errorf("command %q has no handler", name)
+	}
+	if _, ok := commands[name]; ok {
+		return fmt.Errorf("command %q is already registered", name)
+	}
+	commands[name] = paletteCommand{name: name, description: description, handler: handler}
+	return nil
+}
+
+// commandHelp lists every registered command, sorted by name.
+func commandHelp() []string {
+	lines := make([]string, 0, len(commands))
+	for _, c := range commands {
+		lines = append(lines, c.description)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// executeCommand runs the palette input and returns any command it starts.
+func (m *Model) executeCommand() tea.Cmd {
+	cmd, _ := m.runCommand(m.commandInput)
+	return cmd
+}
+
+// runCommand runs one palette command line, toasting the error of a
+// handler that fails. ok is false when the handler failed or the line names
+// no command.
+func (m *Model) runCommand(line string) (cmd tea.Cmd, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, true
+	}
+
+	c, found := commands[strings.ToLower(fields[0])]
+	if !found {
+		m.addToast("UNKNOWN COMMAND", "error")
+		return nil, false
+	}
+
+	var err error
+	if c.raw != nil {
+		cmd, err = c.raw(m, commandArg(line))
+	} else {
+		cmd, err = c.handler(m, fields[1:])
+	}
+	if err != nil {
+		m.addToast(strings.ToUpper(err.Error()), "error")
+		return cmd, false
+	}
+	return cmd, true
+}
+
+// runScript runs the palette commands in script, one per line, skipping
+// blank lines and "#" comments. It stops at the first command that fails
+// and returns its line number, or 0 if all succeeded, with the commands
+// started so far.
+func (m *Model) runScript(script string) (tea.Cmd, int) {
+	var cmds []tea.Cmd
+	for i, line := range strings.Split(script, "\n") {
+		// Only the line ending goes: trailing spaces can matter, as in a prompt
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if fields := strings.Fields(trimmed); strings.ToLower(fields[0]) == "source" {
+			// A script sourcing itself would never finish
+			m.addToast("SCRIPTS CANNOT SOURCE OTHER SCRIPTS", "error")
+			return tea.Batch(cmds...), i + 1
+		}
+		cmd, ok := m.runCommand(line)
+		cmds = append(cmds, cmd)
+		if !ok {
+			return tea.Batch(cmds...), i + 1
+		}
+	}
+	return tea.Batch(cmds...), 0
+}
+
+func cmdTheme(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) == 2 && strings.ToLower(args[0]) == "save" {
+		if err := saveTheme(expandHome(args[1]), m.theme); err != nil {
+			return nil, fmt.Errorf("save failed: %w", err)
+		}
+		m.addToast("THEME SAVED", "success")
+		return nil, nil
+	}
+	if len(args) == 2 && strings.ToLower(args[0]) == "load" {
+		t, err := loadTheme(expandHome(args[1]))
+		if err != nil {
+			return nil, fmt.Errorf("bad theme: %w", err)
+		}
+		m.useTheme(t)
+		m.addToast("THEME LOADED: "+strings.ToUpper(t.Name), "success")
+		return nil, nil
+	}
+	if len(args) != 1 {
+		return nil, errors.New("usage: theme classic|amber|phosphor|load <path>|save <path>")
+	}
+	if err := m.setTheme(args[0]); err != nil {
+		return nil, err
+	}
+	m.addToast("THEME CHANGED", "info")
+	return nil, nil
+}
+
+func cmdTZ(m *Model, args []string) (tea.Cmd, error) {
+	zone := strings.ToLower(strings.Join(args, " "))
+	if zone != "local" && zone != "utc" {
+		return nil, errors.New("usage: tz local|utc")
+	}
+	m.displayUTC = zone == "utc"
+	m.lineCache.invalidate()
+	m.addToast("TIMESTAMPS: "+strings.ToUpper(zone), "info")
+	return nil, nil
+}
+
+func cmdQuiet(m *Model, args []string) (tea.Cmd, error) {
+	quiet := !m.quiet
+	toast := "QUIET MODE: ON"
+	if !quiet {
+		toast = "QUIET MODE: OFF"
+	}
+	m.addToast(toast, "info")
+	return m.setQuiet(quiet), nil
+}
+
+func cmdMatrix(m *Model, args []string) (tea.Cmd, error) {
+	if m.noColor {
+		return nil, errors.New("matrix needs color")
+	}
+	if m.rain != nil {
+		m.rain = nil
+		m.addToast("MATRIX: OFF", "info")
+		return nil, nil
+	}
+	m.rain = &matrixRain{}
+	m.addToast("MATRIX: ON", "info")
+	return nil, nil
+}
+
+func cmdClear(m *Model, args []string) (tea.Cmd, error) {
+	n := greetingLength(m.messages)
+	m.messages = m.messages[:n:n]
+	m.clearSelection()
+	m.scrollOffset = 0
+	m.addToast("MESSAGES CLEARED", "info")
+	return nil, nil
+}
+
+func cmdCursor(m *Model, args []string) (tea.Cmd, error) {
+	arg := strings.ToLower(strings.Join(args, " "))
+	if arg == "blink" {
+		m.cursorBlink = !m.cursorBlink
+		m.addToast("CURSOR BLINK TOGGLED", "info")
+	} else if _, ok := cursorGlyphs[arg]; ok {
+		m.cursorStyle = arg
+		m.addToast("CURSOR: "+strings.ToUpper(arg), "info")
+	} else {
+		return nil, errors.New("cursor: block, bar, underline or blink")
+	}
+	return nil, nil
+}
+
+func cmdVim(m *Model, args []string) (tea.Cmd, error) {
+	m.vimEnabled = !m.vimEnabled
+	m.editorMode = "insert"
+	m.pendingOp = ""
+	if m.vimEnabled {
+		m.addToast("VIM MODE: ON", "info")
+	} else {
+		m.addToast("VIM MODE: OFF", "info")
+	}
+	return nil, nil
+}
+
+func cmdEmacs(m *Model, args []string) (tea.Cmd, error) {
+	m.emacsEnabled = !m.emacsEnabled
+	if m.emacsEnabled {
+		m.addToast("EMACS KEYS: ON (ALT+X OPENS PALETTE)", "info")
+	} else {
+		m.addToast("EMACS KEYS: OFF", "info")
+	}
+	return nil, nil
+}
+
+func cmdHelp(m *Model, args []string) (tea.Cmd, error) {
+	lines := append(append([]string{}, helpLines...), "", "COMMANDS (CTRL+K)")
+	m.report("KEY BINDINGS", append(lines, commandHelp()...))
+	return nil, nil
+}
+
+func cmdNew(m *Model, args []string) (tea.Cmd, error) {
+	m.pushModal(confirmModal{prompt: "START A NEW SESSION?", action: "new"})
+	return nil, nil
+}
+
+func cmdSession(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: session <id>")
+	}
+	if err := validateSessionName(args[0]); err != nil {
+		return nil, err
+	}
+	m.sessionID = args[0]
+	m.addToast("SESSION ID: "+m.sessionID, "info")
+	return nil, nil
+}
+
+func cmdExport(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: export <path>")
+	}
+	if err := m.exportTranscript(expandHome(args[0])); err != nil {
+		return nil, fmt.Errorf("export failed: %w", err)
+	}
+	m.addToast("TRANSCRIPT EXPORTED", "success")
+	return nil, nil
+}
+
+func cmdScreensaver(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, errors.New("usage: screensaver <idle>|off [logo|clock]")
+	}
+	style := m.saverStyle
+	if len(args) == 2 {
+		style = args[1]
+	}
+	var idle time.Duration
+	if strings.ToLower(args[0]) != "off" {
+		var err error
+		if idle, err = time.ParseDuration(args[0]); err != nil || idle <= 0 {
+			return nil, errors.New("idle time must be a duration such as 5m")
+		}
+	}
+	if err := m.setScreensaver(style, idle); err != nil {
+		return nil, err
+	}
+	if idle == 0 {
+		m.addToast("SCREENSAVER: OFF", "info")
+	} else {
+		m.addToast(fmt.Sprintf("SCREENSAVER: %s AFTER %s", strings.ToUpper(style), idle), "info")
+	}
+	return nil, nil
+}
+
+func cmdSource(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: source <path>")
+	}
+	data, err := os.ReadFile(expandHome(args[0]))
+	if err != nil {
+		return nil, fmt.Errorf("source failed: %w", err)
+	}
+	cmd, failed := m.runScript(string(data))
+	if failed > 0 {
+		return cmd, fmt.Errorf("script stopped at line %d", failed)
+	}
+	return cmd, nil
+}
+
+func cmdDumpState(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: dumpstate <path>")
+	}
+	if err := m.dumpState(expandHome(args[0])); err != nil {
+		return nil, fmt.Errorf("dump failed: %w", err)
+	}
+	m.addToast("STATE DUMPED", "success")
+	return nil, nil
+}
+
+func cmdDup(m *Model, args []string) (tea.Cmd, error) {
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+	if err := m.duplicateSession(name); err != nil {
+		return nil, fmt.Errorf("dup failed: %w", err)
+	}
+	m.addToast("NOW IN COPY: "+m.sessionID, "success")
+	return nil, nil
+}
+
+func cmdNotes(m *Model, args []string) (tea.Cmd, error) {
+	m.pushModal(notesModal{text: m.notes})
+	return nil, nil
+}
+
+func cmdSave(m *Model, args []string) (tea.Cmd, error) {
+	path := m.sessionPath()
+	if len(args) > 0 {
+		path = expandHome(args[0])
+	}
+	if err := m.saveSession(path); err != nil {
+		return nil, fmt.Errorf("save failed: %w", err)
+	}
+	m.addToast("SESSION SAVED", "success")
+	return nil, nil
+}
+
+// commandArg returns the text of a palette line after the command name and
+// the one blank that ends it.
+func commandArg(line string) string {
+	line = strings.TrimLeft(line, " \t")
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		return line[i+1:]
+	}
+	return ""
+}
+
+func cmdPrompt(m *Model, text string) (tea.Cmd, error) {
+	m.prompt = text
+	if text == "" {
+		m.addToast("PROMPT RESET", "info")
+	} else {
+		m.addToast("PROMPT: "+text, "info")
+	}
+	return nil, nil
+}
+
+func cmdLayout(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: layout <m>:<e>:<mcp>|auto|vertical|horizontal")
+	}
+	if mode := strings.ToLower(args[0]); mode == "auto" || mode == "vertical" || mode == "horizontal" {
+		m.layoutMode = mode
+		if max := m.maxScrollOffset(); m.scrollOffset > max {
+			m.scrollOffset = max
+		}
+		m.addToast("LAYOUT: "+strings.ToUpper(mode), "info")
+		return nil, nil
+	}
+	ratio, err := parseLayoutRatio(args[0])
+	if err != nil {
+		return nil, err
+	}
+	m.layoutRatio = ratio
+	if max := m.maxScrollOffset(); m.scrollOffset > max {
+		m.scrollOffset = max
+	}
+	m.addToast("LAYOUT: "+args[0], "info")
+	return nil, nil
+}
+
+func cmdSpacing(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: spacing <n>")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errors.New("usage: spacing <n>")
+	}
+	if err := m.setSpacing(n); err != nil {
+		return nil, err
+	}
+	m.addToast(fmt.Sprintf("SPACING: %d", n), "info")
+	return nil, nil
+}
+
+func cmdFPS(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: fps <n>")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errors.New("usage: fps <n>")
+	}
+	_, m.fps = timingForFPS(n)
+	if m.fps != n {
+		m.addToast(fmt.Sprintf("FPS: %d (LIMIT %d-%d)", m.fps, minFPS, maxFPS), "info")
+	} else {
+		m.addToast(fmt.Sprintf("FPS: %d", m.fps), "info")
+	}
+	return nil, nil
+}
+
+func cmdToasts(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: toasts <n>")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return nil, errors.New("usage: toasts <n>")
+	}
+	m.toastConfig.MaxVisible = n
+	if n == 0 {
+		m.addToast("TOASTS: ALL", "info")
+	} else {
+		m.addToast(fmt.Sprintf("TOASTS: %d", n), "info")
+	}
+	return nil, nil
+}
+
+func cmdScrollDelay(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: scrolldelay <ms>")
+	}
+	ms, err := strconv.Atoi(args[0])
+	if err != nil || ms < 0 || ms > 5000 {
+		return nil, errors.New("scroll delay must be 0-5000 ms")
+	}
+	if m.autoScroll == nil {
+		return nil, nil
+	}
+	m.autoScroll.SetDelay(time.Duration(ms) * time.Millisecond)
+	m.addToast(fmt.Sprintf("SCROLL DELAY: %dMS", ms), "info")
+	return nil, nil
+}
+
+func cmdModel(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) == 0 {
+		names := providerNames()
+		cursor := sort.SearchStrings(names, m.modelName)
+		if cursor >= len(names) {
+			cursor = 0
+		}
+		m.pushModal(listModal{title: "SELECT MODEL", action: "model", choices: names, cursor: cursor})
+		return nil, nil
+	}
+	return nil, m.setModel(args[0])
+}
+
+func cmdPing(m *Model, args []string) (tea.Cmd, error) {
+	checker, ok := m.provider.(HealthChecker)
+	if !ok {
+		return nil, fmt.Errorf("ping not supported by %s", m.modelName)
+	}
+	return pingCmd(checker), nil
+}
+
+func cmdGrep(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) == 0 {
+		return nil, errors.New("usage: grep <pattern>")
+	}
+	pattern := strings.Join(args, " ")
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("bad pattern: %w", err)
+	}
+	m.addToast("SEARCHING SESSIONS...", "info")
+	return grepSessionsCmd(m.sessionsDir, pattern, re), nil
+}
+
+func cmdTail(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: tail <path>|stop")
+	}
+	if strings.ToLower(args[0]) == "stop" {
+		if m.tail == nil {
+			return nil, errors.New("not tailing")
+		}
+		m.stopTail()
+		m.addToast("TAIL STOPPED", "info")
+		return nil, nil
+	}
+
+	t, err := startTail(expandHome(args[0]))
+	if err != nil {
+		return nil, fmt.Errorf("tail failed: %w", err)
+	}
+	m.stopTail()
+	m.tail = t
+	m.addToast("TAILING "+filepath.Base(t.path), "info")
+	return waitForTailLine(t), nil
+}
+
+// defaultSummaryExchanges is how many exchanges summarize covers by default.
+const defaultSummaryExchanges = 5
+
+func cmdSummarize(m *Model, args []string) (tea.Cmd, error) {
+	n := defaultSummaryExchanges
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+			return nil, errors.New("usage: summarize [n]")
+		}
+	}
+	if m.isProcessing {
+		return nil, errors.New("wait for the current response")
+	}
+
+	prompt := buildSummaryPrompt(m.messages, n)
+	if prompt == "" {
+		return nil, errors.New("nothing to summarize")
+	}
+	m.isProcessing = true
+	m.addToast("SUMMARIZING...", "info")
+	return summarizeCmd(m.requestContext("summary"), m.provider, prompt), nil
+}
+
+// buildSummaryPrompt asks for a summary of the last n exchanges, each a user
+// message and the replies after it. It returns "" when there is no user
+// message to summarize.
+func buildSummaryPrompt(messages []Message, n int) string {
+	start, found := len(messages), 0
+	for i := len(messages) - 1; i >= 0 && found < n; i-- {
+		if messages[i].Role == "user" {
+			start = i
+			found++
+		}
+	}
+	if found == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Summarize the following %d exchange(s) concisely:\n\n", found)
+	for _, msg := range messages[start:] {
+		if msg.Role == "user" || msg.Role == "assistant" {
+			fmt.Fprintf(&b, "%s: %s\n", strings.ToUpper(msg.Role), msg.Content)
+		}
+	}
+	return b.String()
+}
+
+func cmdOutput(m *Model, args []string) (tea.Cmd, error) {
+	m.toggleOutput()
+	return nil, nil
+}
+
+func cmdPerf(m *Model, args []string) (tea.Cmd, error) {
+	if m.perf == nil {
+		return nil, nil
+	}
+	row := func(name string, r rollingAverage) string {
+		return fmt.Sprintf("%-7s AVG %-10s LAST %s", name, r.Average().Round(time.Microsecond), r.last.Round(time.Microsecond))
+	}
+	m.report(fmt.Sprintf("PERF (LAST %d CALLS)", perfWindow),
+		[]string{row("UPDATE", m.perf.update), row("VIEW", m.perf.view)})
+	return nil, nil
+}
+
+func cmdNotifications(m *Model, args []string) (tea.Cmd, error) {
+	if m.notifications == nil || m.notifications.Len() == 0 {
+		m.addToast("NO NOTIFICATIONS", "info")
+		return nil, nil
+	}
+	toasts := m.notifications.Items()
+	lines := make([]string, len(toasts))
+	for i, toast := range toasts {
+		lines[i] = fmt.Sprintf("%s %-7s %s", formatTimestamp(toast.CreatedAt, m.displayUTC),
+			strings.ToUpper(toast.Type), toast.Message)
+	}
+	m.report("NOTIFICATIONS", lines)
+	return nil, nil
+}
+
+func cmdReactions(m *Model, args []string) (tea.Cmd, error) {
+	annotated := annotatedMessages(m.messages)
+	if len(annotated) == 0 {
+		m.addToast("NO REACTIONS", "info")
+		return nil, nil
+	}
+	lines := make([]string, len(annotated))
+	for i, msg := range annotated {
+		lines[i] = truncateRunes(fmt.Sprintf("%s #%d %s: %s", msg.Reaction, msg.ID,
+			strings.ToUpper(msg.Role), strings.ReplaceAll(msg.Content, "\n", " ")), 72)
+	}
+	m.report("REACTIONS", lines)
+	return nil, nil
+}
+
+func cmdIDs(m *Model, args []string) (tea.Cmd, error) {
+	m.toggleIDs()
+	return nil, nil
+}
+
+// messageArg resolves the single message ID argument of a command,
+// returning the usage or the lookup error when it can't.
+func messageArg(m *Model, usage string, args []string) (int, error) {
+	if len(args) != 1 {
+		return -1, errors.New("usage: " + usage)
+	}
+	return m.resolveMessage(args[0])
+}
+
+func cmdDelete(m *Model, args []string) (tea.Cmd, error) {
+	i, err := messageArg(m, "delete <id>", args)
+	if err != nil {
+		return nil, err
+	}
+	id := m.messages[i].ID
+	if err := m.deleteMessage(i); err != nil {
+		return nil, err
+	}
+	m.addToast(fmt.Sprintf("DELETED #%d", id), "success")
+	return nil, nil
+}
+
+func cmdPin(m *Model, args []string) (tea.Cmd, error) {
+	i, err := messageArg(m, "pin <id>", args)
+	if err != nil {
+		return nil, err
+	}
+	msg := &m.messages[i]
+	msg.Pinned = !msg.Pinned
+	if msg.Pinned {
+		m.addToast(fmt.Sprintf("PINNED #%d", msg.ID), "info")
+	} else {
+		m.addToast(fmt.Sprintf("UNPINNED #%d", msg.ID), "info")
+	}
+	return nil, nil
+}
+
+func cmdCopy(m *Model, args []string) (tea.Cmd, error) {
+	i, err := messageArg(m, "copy <id>", args)
+	if err != nil {
+		return nil, err
+	}
+	m.addToast(fmt.Sprintf("COPIED #%d", m.messages[i].ID), "success")
+	return copyToClipboard(formatTranscript(m.messages[i : i+1])), nil
+}
+
+func cmdGoto(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) == 1 && (args[0] == "first" || args[0] == "last") {
+		if len(m.messages) == 0 {
+			return nil, errors.New("no messages")
+		}
+		i := 0
+		if args[0] == "last" {
+			i = len(m.messages) - 1
+		}
+		m.scrollToMessage(i)
+		return nil, nil
+	}
+	i, err := messageArg(m, "goto <id>|first|last", args)
+	if err != nil {
+		return nil, err
+	}
+	m.scrollToMessage(i)
+	return nil, nil
+}
+
+func cmdStats(m *Model, args []string) (tea.Cmd, error) {
+	stats := fmt.Sprintf("TOKENS: %d | COST: $%.2f | AVG LATENCY: %s",
+		m.contextTokens, m.cost, m.latency.Average().Round(time.Millisecond))
+	if m.showOutput {
+		m.report("STATS", []string{stats})
+		return nil, nil
+	}
+	m.addToast(stats, "info")
+	return nil, nil
+}
+
+func cmdToastPos(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: toastpos " + strings.Join(toastPositions, "|"))
+	}
+	if err := m.setToastPosition(args[0]); err != nil {
+		return nil, err
+	}
+	m.addToast("TOASTS: "+strings.ToUpper(m.toastConfig.Position), "info")
+	return nil, nil
+}
+
+func cmdWidth(m *Model, args []string) (tea.Cmd, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: width <n>")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errors.New("usage: width <n>")
+	}
+	if err := m.setMaxContentWidth(n); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		m.addToast("WIDTH: FULL", "info")
+	} else {
+		m.addToast(fmt.Sprintf("WIDTH: %d", n), "info")
+	}
+	return nil, nil
+}
+
+func (m Model) applyGlitch(content string) string {
+	lines := strings.Split(content, "\n")
+
+	// Random glitch lines
+	for i := 0; i < 3; i++ {
+		y := rand.Intn(len(lines))
+		if y < len(lines) {
+			runes := []rune(lines[y])
+			for j := 0; j < 5; j++ {
+				x := rand.Intn(len(runes))
+				if x < len(runes) {
+					runes[x] = []rune(glitchChars[rand.Intn(len(glitchChars))])[0]
+				}
+			}
+			lines[y] = string(runes)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (m Model) applyScanline(content string) string {
+	if m.noColor || !m.scanlines {
+		return content
+	}
+
+	dim := func(line string) string {
+		return m.styles.muted.Render(line)
+	}
+	return m.frames.render(content, m.scanlineY, dim)
+}
+
+// frameCache remembers the last frame before and after post-processing,
+// keyed on the composed frame and the effect line. A frame that matches
+// the key is returned as is; otherwise only lines that changed, and the
+// effect line, are processed again. It is shared across model copies and
+// must be invalidated when the effect's style changes; a nil cache
+// processes every line.
+type frameCache struct {
+	frame     string // composed frame the output was built from
+	output    string
+	raw       []string
+	processed []string
+	effectY   int // line the effect was applied to
+}
+
+// render post-processes frame with effect on line y.
+func (c *frameCache) render(frame string, y int, effect func(string) string) string {
+	if c != nil && c.raw != nil && c.frame == frame && c.effectY == y {
+		return c.output
+	}
+	out := strings.Join(c.apply(strings.Split(frame, "\n"), y, effect), "\n")
+	if c != nil {
+		c.frame, c.output = frame, out
+	}
+	return out
+}
+
+// invalidate forgets the last frame.
+func (c *frameCache) invalidate() {
+	if c != nil {
+		*c = frameCache{effectY: -1}
+	}
+}
+
+// changedLines reports for each line whether it differs from the same line
+// of the previous frame.
+func (c *frameCache) changedLines(lines []string) []bool {
+	changed := make([]bool, len(lines))
+	for i, line := range lines {
+		changed[i] = c == nil || i >= len(c.raw) || c.raw[i] != line
+	}
+	return changed
+}
+
+// apply runs effect on line y of lines, reusing the previous frame's output
+// for every line that is unchanged and wasn't or isn't the effect line.
+func (c *frameCache) apply(lines []string, y int, effect func(string) string) []string {
+	changed := c.changedLines(lines)
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		switch {
+		case i == y:
+			if !changed[i] && c.effectY == y {
+				out[i] = c.processed[i]
+			} else {
+				out[i] = effect(line)
+			}
+		case !changed[i] && i != c.effectY:
+			out[i] = c.processed[i]
+		default:
+			out[i] = line
+		}
+	}
+
+	if c != nil {
+		c.raw, c.processed, c.effectY = lines, out, y
+	}
+	return out
+}
+
+// messageSegment is a run of prose or one fenced code block of a message.
+type messageSegment struct {
+	code bool
+	lang string // info string of a code fence, e.g. "go"
+	text string
+}
+
+// splitSegments splits content into prose and ```-fenced code blocks. An
+// unclosed fence runs to the end of the content.
+func splitSegments(content string) []messageSegment {
+	var segs []messageSegment
+	var cur messageSegment
+	var body []string
+
+	flush := func() {
+		if text := strings.Join(body, "\n"); cur.code || strings.TrimSpace(text) != "" {
+			cur.text = text
+			segs = append(segs, cur)
+		}
+		body = nil
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "```") {
+			body = append(body, line)
+			continue
+		}
+		flush()
+		if cur.code {
+			cur = messageSegment{}
+		} else {
+			cur = messageSegment{code: true, lang: strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))}
+		}
+	}
+	flush()
+
+	return segs
+}
+
+// CodeBlock is a fenced code block found in a message.
+type CodeBlock struct {
+	Lang string
+	Code string
+}
+
+// extractCodeBlocks returns the fenced code blocks of content in order,
+// without the surrounding prose.
+func extractCodeBlocks(content string) []CodeBlock {
+	var blocks []CodeBlock
+	for _, seg := range splitSegments(content) {
+		if seg.code {
+			blocks = append(blocks, CodeBlock{Lang: seg.lang, Code: seg.text})
+		}
+	}
+	return blocks
+}
+
+var (
+	lexerMu    sync.Mutex
+	lexerCache = map[string]chroma.Lexer{} // by language; nil for unknown
+)
+
+// cachedLexer returns the chroma lexer for lang, or nil if there is none.
+func cachedLexer(lang string) chroma.Lexer {
+	lang = strings.ToLower(lang)
+	lexerMu.Lock()
+	defer lexerMu.Unlock()
+
+	lexer, ok := lexerCache[lang]
+	if !ok {
+		if lang != "" {
+			lexer = lexers.Get(lang)
+		}
+		lexerCache[lang] = lexer
+	}
+	return lexer
+}
+
+// highlightCode colors code written in lang with the palette of s. Unknown
+// languages, and code the lexer rejects, are returned unchanged.
+func highlightCode(code, lang string, s *styles) string {
+	lexer := cachedLexer(lang)
+	if lexer == nil {
+		return code
+	}
+	it, err := lexer.Tokenise(nil, code)
+	if err != nil {
+		return code
+	}
+
+	var b strings.Builder
+	for _, tok := range it.Tokens() {
+		style := tokenStyle(tok.Type, s)
+		// Style each line on its own so no escape sequence spans a newline
+		for i, part := range strings.Split(tok.Value, "\n") {
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			if part != "" {
+				b.WriteString(style.Render(part))
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// tokenStyle maps a chroma token type onto the theme colors.
+func tokenStyle(t chroma.TokenType, s *styles) lipgloss.Style {
+	style := lipgloss.NewStyle()
+	switch {
+	case t.InCategory(chroma.Keyword):
+		return style.Foreground(s.pink).Bold(true)
+	case t.InSubCategory(chroma.LiteralString):
+		return style.Foreground(s.amber)
+	case t.InSubCategory(chroma.LiteralNumber):
+		return style.Foreground(s.purple)
+	case t.InCategory(chroma.Comment):
+		return style.Foreground(s.mediumGray).Italic(true)
+	case t.InCategory(chroma.Operator), t.InCategory(chroma.Punctuation):
+		return style.Foreground(s.blue)
+	}
+	return style.Foreground(s.green)
+}
+
+// urlPattern matches http and https URLs up to whitespace or a quote.
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// wrapLinks word-wraps text to width and wraps each URL in an OSC 8
+// hyperlink so terminals that support it make the URL clickable. URLs are
+// found before wrapping, so each fragment of a URL broken across lines still
+// links to the whole URL. The visible text is unchanged.
+func wrapLinks(text string, width int) []string {
+	lines := wordWrap(text, width)
+	links := findLinks(text)
+	if len(links) == 0 {
+		return lines
+	}
+
+	// Every wrapped word is a piece of text, in order; find each one to
+	// know which URL, if any, it came from
+	pos := 0
+	for i, line := range lines {
+		var b strings.Builder
+		for j, word := range strings.Split(line, " ") {
+			if j > 0 {
+				b.WriteByte(' ')
+			}
+			start := pos + strings.Index(text[pos:], word)
+			pos = start + len(word)
+			b.WriteString(linkSpan(text, start, pos, links))
+		}
+		lines[i] = b.String()
+	}
+	return lines
+}
+
+// findLinks returns the byte ranges of the URLs in text.
+func findLinks(text string) [][2]int {
+	var links [][2]int
+	for _, loc := range urlPattern.FindAllStringIndex(text, -1) {
+		// Trailing punctuation usually ends the sentence, not the URL
+		trimmed := strings.TrimRight(text[loc[0]:loc[1]], ".,;:!?)]")
+		links = append(links, [2]int{loc[0], loc[0] + len(trimmed)})
+	}
+	return links
+}
+
+// linkSpan returns text[start:end] with the parts that fall inside links
+// hyperlinked to the full URL they belong to.
+func linkSpan(text string, start, end int, links [][2]int) string {
+	var b strings.Builder
+	for _, link := range links {
+		if link[1] <= start || link[0] >= end {
+			continue
+		}
+		from, to := max(start, link[0]), min(end, link[1])
+		b.WriteString(text[start:from])
+		b.WriteString(hyperlink(text[link[0]:link[1]], text[from:to]))
+		start = to
+	}
+	b.WriteString(text[start:end])
+	return b.String()
+}
+
+// hyperlink formats label as an OSC 8 hyperlink to url.
+func hyperlink(url, label string) string {
+	return "\x1b]8;;" + url + "\x1b\\" + label + "\x1b]8;;\x1b\\"
+}
+
+func wordWrap(text string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+
+	var lines []string
+	words := strings.Fields(text)
+
+	var currentLine string
+	for _, word := range words {
+		// Break words longer than a whole line into width-sized chunks
+		for utf8.RuneCountInString(word) > width {
+			if currentLine != "" {
+				lines = append(lines, currentLine)
+				currentLine = ""
+			}
+			runes := []rune(word)
+			lines = append(lines, string(runes[:width]))
+			word = string(runes[width:])
+		}
+
+		if currentLine == "" {
+			currentLine = word
+		} else if utf8.RuneCountInString(currentLine)+1+utf8.RuneCountInString(word) <= width {
+			currentLine += " " + word
+		} else {
+			lines = append(lines, currentLine)
+			currentLine = word
+		}
+	}
+
+	if currentLine != "" {
+		lines = append(lines, currentLine)
+	}
+
+	return lines
+}
+
+// pathCommands are the palette commands whose argument is a file path.
+var pathCommands = map[string]bool{
+	"export":    true,
+	"dumpstate": true,
+	"save":      true,
+	"source":    true,
+	"tail":      true,
+}
+
+// expandHome replaces a leading "~" with the user's home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return home + path[1:]
+}
+
+// completePath extends partial to the longest common prefix of the
+// directory entries it matches and returns the matching names. Directories
+// are suffixed with "/". A leading "~" is expanded for reading but kept in
+// the result.
+func completePath(partial string) (string, []string, error) {
+	cut := strings.LastIndex(partial, "/") + 1
+	dir, base := partial[:cut], partial[cut:]
+
+	readDir := expandHome(dir)
+	if readDir == "" {
+		readDir = "."
+	}
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return partial, nil, err
+	}
+
+	var matches []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		if entry.IsDir() {
+			name += "/"
+		}
+		matches = append(matches, name)
+	}
+	if len(matches) == 0 {
+		return partial, nil, nil
+	}
+
+	prefix := []rune(matches[0])
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(match, string(prefix)) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return dir + string(prefix), matches, nil
+}
+
+// overlayBlock draws each line of a multi-line block over the content,
+// starting at column x of line y.
+func overlayBlock(content, block string, x, y int) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range strings.Split(block, "\n") {
+		if row := y + i; row >= 0 && row < len(lines) {
+			lines[row] = overlayString(lines[row], line, x, 0)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// overlayString draws overlay over base starting at display column x. Both
+// may be styled: columns are counted in terminal cells, skipping escape
+// sequences, and base's styling resumes after the overlay. The overlay is
+// cut at the right edge of base.
+func overlayString(base, overlay string, x, y int) string {
+	if y != 0 {
+		return base
+	}
+	if x < 0 {
+		overlay = cellSlice(overlay, -x, -1)
+		x = 0
+	}
+
+	baseWidth := lipgloss.Width(base)
+	if room := baseWidth - x; room > 0 && lipgloss.Width(overlay) > room {
+		overlay = cellSlice(overlay, 0, room)
+	}
+	width := lipgloss.Width(overlay)
+
+	head := cellSlice(base, 0, x)
+	if baseWidth < x {
+		head += strings.Repeat(" ", x-baseWidth)
+	}
+	tail := cellSlice(base, x+width, -1)
+	if !strings.Contains(base, "\x1b") && !strings.Contains(overlay, "\x1b") {
+		return head + overlay + tail
+	}
+	// Resets keep base's colors out of the overlay and the overlay's out of
+	// the tail, which re-emits the styling in effect where it starts
+	return head + "\x1b[0m" + overlay + "\x1b[0m" + tail
+}
+
+// cellSlice returns the part of s covering display cells [start, end), or
+// to the end of s when end is negative. Escape sequences are kept, and the
+// SGR styling in effect at start is emitted first. A wide character cut by
+// either edge becomes spaces.
+func cellSlice(s string, start, end int) string {
+	var b strings.Builder
+	var sgr strings.Builder // SGR sequences since the last reset
+	started := false
+	col := 0
+	for i := 0; i < len(s); {
+		if end >= 0 && col >= end {
+			break
+		}
+		if !started && col >= start {
+			b.WriteString(sgr.String())
+			started = true
+		}
+
+		if s[i] == '\x1b' {
+			seq := s[i : i+escapeLen(s[i:])]
+			if strings.HasPrefix(seq, "\x1b[") && strings.HasSuffix(seq, "m") {
+				if seq == "\x1b[0m" || seq == "\x1b[m" {
+					sgr.Reset()
+				} else {
+					sgr.WriteString(seq)
+				}
+			}
+			if started {
+				b.WriteString(seq)
+			}
+			i += len(seq)
+			continue
+		}
+
+		_, size := utf8.DecodeRuneInString(s[i:])
+		cell := s[i : i+size]
+		w := uniseg.StringWidth(cell)
+		switch {
+		case col >= start && (end < 0 || col+w <= end):
+			b.WriteString(cell)
+		case col+w > start:
+			// A wide character straddling an edge
+			from, to := max(col, start), col+w
+			if end >= 0 {
+				to = min(to, end)
+			}
+			if !started {
+				b.WriteString(sgr.String())
+				started = true
+			}
+			b.WriteString(strings.Repeat(" ", to-from))
+		}
+		col += w
+		i += size
+	}
+	return b.String()
+}
+
+// escapeLen returns the length of the escape sequence at the start of s:
+// a CSI sequence, an OSC sequence ended by BEL or ST, or a two-byte escape.
+func escapeLen(s string) int {
+	if len(s) < 2 {
+		return len(s)
+	}
+	switch s[1] {
+	case '[':
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1
+			}
+		}
+	case ']':
+		for i := 2; i < len(s); i++ {
+			if s[i] == '\a' {
+				return i + 1
+			}
+			if s[i] == '\x1b' && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2
+			}
+		}
+	default:
+		return 2
+	}
+	return len(s)
+}
+
+func generateResponse(input, tool string) string {
+	responses := map[string][]string{
+		"file_reader": {
+			"Analyzing file structure... Found 42 components across 7 modules.",
+			"File scan complete. Detected TypeScript, Go, and configuration files.",
+			"Reading directory tree... 1,337 files processed.",
+		},
+		"code_analyzer": {
+			"Code analysis initiated. Detecting patterns and potential optimizations.",
+			"Found 3 performance bottlenecks and 7 style violations.",
+			"Analysis complete. Code quality score: 8.5/10.",
+		},
+		"web_search": {
+			"Searching the retro-net... Found 256 relevant results.",
+			"Web crawl complete. Top result confidence: 94.2%.",
+			"Search terminated. Data packets retrieved successfully.",
+		},
+		"calculator": {
+			"Computing... Result: 42. The answer to everything.",
+			"Calculation complete. Quantum probability: 0.9999.",
+			"Mathematical operation successful. Check MCP logs for details.",
+		},
+	}
+
+	toolResponses := responses[tool]
+	if toolResponses == nil {
+		toolResponses = responses["file_reader"]
+	}
+
+	return toolResponses[rand.Intn(len(toolResponses))]
+}
+
+// ============================================================================
+// Boot Sequence
+// ============================================================================
+
+// bootLines are typed out by the boot sequence before the UI appears.
+var bootLines = []string{
+	"RETRO-DGMO BIOS v2.0  (C) 1987 DGMO SYSTEMS",
+	"",
+	"CPU: Z80-COMPATIBLE @ 4.77 MHZ ......... OK",
+	"MEMORY TEST: 640K ...................... OK",
+	"DETECTING MCP BUS ...................... 1 DEVICE",
+	"LOADING PHOSPHOR DRIVERS ............... OK",
+	"",
+	"BOOTING RETRO-DGMO TERMINAL...",
+}
+
+const (
+	bootCharsPerTick = 6 // characters typed each tick
+	bootFadeTicks    = 4 // ticks the finished text fades before the UI shows
+)
+
+// bootLength is the number of characters the boot sequence types.
+func bootLength() int {
+	n := 0
+	for _, line := range bootLines {
+		n += utf8.RuneCountInString(line) + 1
 	}
+	return n
+}
 
-	title := " MESSAGES "
-	content := []string{}
+// advanceBoot types the next characters of the boot sequence, then fades
+// it out and ends it. It runs on every tick while booting.
+func (m *Model) advanceBoot() {
+	if m.bootTyped < bootLength() {
+		m.bootTyped += bootCharsPerTick
+		return
+	}
+	m.bootFade++
+	if m.bootFade >= bootFadeTicks {
+		m.booting = false
+	}
+}
 
-	for _, msg := range m.messages {
-		var msgStyle lipgloss.Style
-		prefix := ""
+// renderBoot draws the part of the boot text typed so far, with a cursor
+// while typing and in fading colors afterwards.
+func (m Model) renderBoot() string {
+	style := lipgloss.NewStyle().Foreground(m.styles.green)
+	if m.bootFade > 0 {
+		style = style.Faint(true)
+		if m.bootFade >= bootFadeTicks/2 {
+			style = lipgloss.NewStyle().Foreground(m.styles.mediumGray)
+		}
+	}
+
+	var lines []string
+	left := m.bootTyped
+	for _, line := range bootLines {
+		runes := []rune(line)
+		if left <= len(runes) {
+			lines = append(lines, string(runes[:left])+"█")
+			break
+		}
+		lines = append(lines, line)
+		left -= len(runes) + 1
+	}
+	if m.bootFade > 0 {
+		// Typing is done: drop the cursor
+		lines[len(lines)-1] = strings.TrimSuffix(lines[len(lines)-1], "█")
+	}
+	return style.Padding(1, 2).Render(strings.Join(lines, "\n"))
+}
+
+// ============================================================================
+// Matrix Rain
+// ============================================================================
+
+// rainGlyphs are the characters the rain is made of, all one cell wide.
+var rainGlyphs = []rune("ｱｲｳｴｵｶｷｸｹｺｻｼｽｾｿﾀﾁﾂﾃﾄﾅﾆﾇﾈﾉ0123456789")
+
+// rainDrop is the falling trail of one column.
+type rainDrop struct {
+	y      int // row of the head; negative while waiting to enter
+	speed  int // ticks per row
+	length int // rows in the trail behind the head
+}
+
+func newRainDrop(height int) rainDrop {
+	return rainDrop{y: -rand.Intn(height + 1), speed: 1 + rand.Intn(3), length: 4 + rand.Intn(8)}
+}
 
-		switch msg.Role {
-		case "user":
-			msgStyle = userMsgStyle.Width(width - 6)
-			prefix = "USER> "
-		case "assistant":
-			msgStyle = aiMsgStyle.Width(width - 6)
-			prefix = "AI> "
-			if msg.Tool != "" {
-				prefix = fmt.Sprintf("AI[%s]> ", msg.Tool)
+// matrixRain is the falling-character background toggled with the
+// "matrix" command. It is advanced by the tick loop and shared across
+// model copies.
+type matrixRain struct {
+	drops []rainDrop
+	tick  int
+}
+
+// advance moves every drop down at its own speed, sizing the rain to a
+// width by height screen. Drops that have left the screen start over.
+func (r *matrixRain) advance(width, height int) {
+	if r == nil {
+		return
+	}
+	for len(r.drops) < width {
+		r.drops = append(r.drops, newRainDrop(height))
+	}
+	r.drops = r.drops[:width]
+	r.tick++
+	for i := range r.drops {
+		d := &r.drops[i]
+		if r.tick%d.speed == 0 {
+			d.y++
+		}
+		if d.y-d.length > height {
+			*d = newRainDrop(height)
+		}
+	}
+}
+
+// apply draws the rain behind the rendered frame in s's colors: only
+// background cells get a raindrop, so the foreground text and its escape
+// sequences are untouched.
+func (r *matrixRain) apply(frame string, s *styles) string {
+	if r == nil || len(r.drops) == 0 {
+		return frame
+	}
+	head := lipgloss.NewStyle().Foreground(s.green)
+	trail := lipgloss.NewStyle().Foreground(s.green).Faint(true)
+
+	lines := strings.Split(frame, "\n")
+	for y := range lines {
+		cells := make(map[int]string)
+		for x, d := range r.drops {
+			if y > d.y || y < d.y-d.length {
+				continue
+			}
+			glyph := string(rainGlyphs[(x*31+y*17+r.tick/4)%len(rainGlyphs)])
+			if y == d.y {
+				cells[x] = head.Render(glyph)
+			} else {
+				cells[x] = trail.Render(glyph)
+			}
+		}
+		lines[y] = overlayCells(lines[y], cells)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// overlayCells draws cells, keyed by column, over the background columns
+// of a rendered line that may hold ANSI escape sequences. The sequences and
+// the visible text are kept as they are, and after each drawn cell the
+// styles in effect at that point are restored.
+func overlayCells(line string, cells map[int]string) string {
+	if len(cells) == 0 {
+		return line
+	}
+	background := backgroundCols(line)
+	var b strings.Builder
+	var sgr strings.Builder // SGR sequences since the last reset
+	col := 0
+	for i := 0; i < len(line); {
+		if line[i] == '\x1b' {
+			seq := line[i : i+escapeLen(line[i:])]
+			b.WriteString(seq)
+			if strings.HasPrefix(seq, "\x1b[") && strings.HasSuffix(seq, "m") {
+				if seq == "\x1b[0m" || seq == "\x1b[m" {
+					sgr.Reset()
+				} else {
+					sgr.WriteString(seq)
+				}
 			}
-		case "system":
-			msgStyle = lipgloss.NewStyle().Foreground(crtGreen).Bold(true)
-			prefix = "SYS> "
+			i += len(seq)
+			continue
 		}
 
-		lines := wordWrap(prefix+msg.Content, width-8)
-		for _, line := range lines {
-			content = append(content, msgStyle.Render(line))
+		_, size := utf8.DecodeRuneInString(line[i:])
+		if cell, ok := cells[col]; ok && background[col] {
+			b.WriteString(cell)
+			b.WriteString(sgr.String())
+		} else {
+			b.WriteString(line[i : i+size])
 		}
-		content = append(content, "") // Space between messages
+		col += uniseg.StringWidth(line[i : i+size])
+		i += size
 	}
+	return b.String()
+}
 
-	// Apply scrolling
-	visibleContent := content
-	if len(content) > height-4 {
+// backgroundCols reports which columns of a rendered line are background:
+// blanks in a run that reaches the edge of the line or a border. A run of
+// blanks with text on both sides is a gap between words and isn't
+// background.
+func backgroundCols(line string) map[int]bool {
+	type cell struct {
+		col int
+		r   rune
+	}
+	var cells []cell
+	col := 0
+	for i := 0; i < len(line); {
+		if line[i] == '\x1b' {
+			i += escapeLen(line[i:])
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(line[i:])
+		cells = append(cells, cell{col, r})
+		col += uniseg.StringWidth(line[i : i+size])
+		i += size
+	}
+
+	background := make(map[int]bool)
+	for i := 0; i < len(cells); {
+		if cells[i].r != ' ' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(cells) && cells[j].r == ' ' {
+			j++
+		}
+		if i == 0 || j == len(cells) || isBorderRune(cells[i-1].r) || isBorderRune(cells[j].r) {
+			for _, c := range cells[i:j] {
+				background[c.col] = true
+			}
+		}
+		i = j
+	}
+	return background
+}
+
+// isBorderRune reports whether r is a box-drawing or block character, as
+// pane borders are drawn with.
+func isBorderRune(r rune) bool {
+	return r >= 0x2500 && r <= 0x259f
+}
+
+// ============================================================================
+// Screensaver
+// ============================================================================
+
+// screensaverStyles are the animations the screensaver can show.
+var screensaverStyles = []string{"logo", "clock"}
+
+const screensaverLogo = "◼ RETRO-DGMO ◼"
+
+// checkIdle starts the screensaver once no key has been pressed for
+// idleTimeout. It runs on every tick.
+func (m *Model) checkIdle(now time.Time) {
+	if m.idleTimeout > 0 && !m.screensaver && now.Sub(m.lastInput) >= m.idleTimeout {
+		m.screensaver = true
+		m.saverStart = m.frame
+	}
+}
+
+// wake records a keypress, reporting whether it dismissed the screensaver.
+func (m *Model) wake(now time.Time) bool {
+	m.lastInput = now
+	if !m.screensaver {
+		return false
+	}
+	m.screensaver = false
+	return true
+}
+
+// setScreensaver picks the screensaver style and how long the UI must be
+// idle before it shows; an idle time of 0 turns it off.
+func (m *Model) setScreensaver(style string, idle time.Duration) error {
+	style = strings.ToLower(style)
+	found := false
+	for _, s := range screensaverStyles {
+		found = found || s == style
+	}
+	if !found {
+		return fmt.Errorf("unknown screensaver %q", style)
+	}
+	if idle < 0 {
+		return fmt.Errorf("idle time must not be negative")
+	}
+	m.saverStyle, m.idleTimeout = style, idle
+	return nil
+}
+
+// bounce moves back and forth between 0 and span as n counts up.
+func bounce(n, span int) int {
+	if span <= 0 {
+		return 0
+	}
+	n %= 2 * span
+	if n > span {
+		return 2*span - n
+	}
+	return n
+}
+
+// renderScreensaver draws the screensaver over the whole terminal: the
+// logo bouncing around the screen, or the time in the middle of it.
+func (m Model) renderScreensaver() string {
+	text := screensaverLogo
+	ticks := m.frame - m.saverStart
+	x := bounce(ticks, m.width-lipgloss.Width(text))
+	y := bounce(ticks/2, m.height-1)
+	color := []lipgloss.Color{m.styles.green, m.styles.amber, m.styles.blue, m.styles.pink, m.styles.purple}[ticks/20%5]
+	if m.saverStyle == "clock" {
+		text = formatTimestamp(m.clock(), m.displayUTC)
+		x = (m.width - lipgloss.Width(text)) / 2
+		y = m.height / 2
+		color = m.styles.green
+	}
+
+	rows := make([]string, m.height)
+	for i := range rows {
+		rows[i] = strings.Repeat(" ", m.width)
+	}
+	if y >= 0 && y < len(rows) && x >= 0 {
+		rows[y] = strings.Repeat(" ", x) + lipgloss.NewStyle().Foreground(color).Bold(true).Render(text)
+	}
+	return strings.Join(rows, "\n")
+}
+
+// ============================================================================
+// Idle Ticking
+// ============================================================================
+
+// animating reports whether anything needs TickMsg: toasts to expire,
+// progress to advance, a response on its way, or an effect that moves.
+// With an idle timeout set the tick also watches for the screensaver.
+func (m Model) animating() bool {
+	if m.booting || m.screensaver || m.rain != nil || m.cursorBlink || m.isProcessing {
+		return true
+	}
+	if len(m.toasts) > 0 || m.idleTimeout > 0 {
+		return true
+	}
+	for _, op := range m.mcpOps {
+		if op.Status == "running" {
+			return true
+		}
+	}
+	return false
+}
+
+// resumeTicking restarts the tick loop after an update that gave it
+// something to animate, e.g. a new toast or a send. It runs after every
+// update so no caller has to remember to.
+func (m Model) resumeTicking(cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	if m.ticking || !m.animating() {
+		return m, cmd
+	}
+	m.ticking = true
+	return m, tea.Batch(cmd, tickCmd(m.timing().tick))
+}
+
+// scheduleClock keeps the status bar clock current while the tick loop is
+// stopped, waking once a second instead of every frame.
+func (m *Model) scheduleClock() tea.Cmd {
+	if m.clockPending {
+		return nil
+	}
+	m.clockPending = true
+	return tea.Every(time.Second, func(t time.Time) tea.Msg {
+		return ClockMsg(t)
+	})
+}
+
+// ============================================================================
+// Plain Mode
+// ============================================================================
+
+// renderPlain is the -plain layout for screen readers: a header line, the
+// messages top to bottom as unstyled text, any toasts, and the prompt. It
+// has no borders, columns, colors or effects, so every line reads as text.
+func (m Model) renderPlain() string {
+	header := fmt.Sprintf("RETRO-DGMO | SESSION %s | MODEL %s", m.sessionID, strings.ToUpper(m.modelName))
+	lines := []string{truncateRunes(header, m.width)}
+
+	rows := m.plainMessageRows()
+	if modal := m.topModal(); modal != nil {
+		body := plainModal(modal, m.width, m.mainHeight(), m.styles)
+		if len(body) > rows {
+			body = body[:rows]
+		}
+		lines = append(lines, body...)
+	} else {
+		body := m.plainMessageLines()
 		start := m.scrollOffset
-		if start > len(content)-height+4 {
-			start = len(content) - height + 4
+		if start > len(body)-rows {
+			start = len(body) - rows
 		}
 		if start < 0 {
 			start = 0
 		}
-		end := start + height - 4
-		if end > len(content) {
-			end = len(content)
+		end := start + rows
+		if end > len(body) {
+			end = len(body)
 		}
-		visibleContent = content[start:end]
+		lines = append(lines, body[start:end]...)
 	}
 
-	inner := strings.Join(visibleContent, "\n")
-	return style.Render(lipgloss.JoinVertical(lipgloss.Left, title, inner))
+	lines = append(lines, m.plainFooter()...)
+	return strings.Join(lines, "\n")
 }
 
-func (m Model) renderEditor(width, height int) string {
-	style := editorStyle.Width(width - 2).Height(height - 2)
-	if m.activePane == "editor" {
-		style = style.BorderForeground(crtPink)
+// plainMessageLines is the conversation as wrapped plain text, in order.
+func (m Model) plainMessageLines() []string {
+	var lines []string
+	for _, msg := range m.messages {
+		lines = append(lines, m.plainMessage(msg)...)
+		for j := 0; j < m.spacing; j++ {
+			lines = append(lines, "")
+		}
+	}
+	return lines
+}
+
+// plainMessage renders one message as "[time] ROLE: content", wrapped to
+// the terminal width. Line breaks in the content are kept.
+func (m Model) plainMessage(msg Message) []string {
+	role := strings.ToUpper(msg.Role)
+	if msg.Tool != "" {
+		role += " (" + strings.ReplaceAll(msg.Tool, "_", " ") + ")"
+	}
+	head := "[" + formatTimestamp(msg.Timestamp, m.displayUTC) + "] " + role + ":"
+	if msg.Reaction != "" {
+		head = msg.Reaction + " " + head
+	}
+	if m.showIDs {
+		head = fmt.Sprintf("#%d ", msg.ID) + head
+	}
+	text := head + " " + msg.Content
+	if msg.Failed {
+		text += "\nFAILED: " + msg.Error
+	}
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		lines = append(lines, wordWrap(line, safeWidth(m.width, 1))...)
+	}
+	return lines
+}
+
+// plainFooter is the toasts and the prompt, or the open command palette.
+func (m Model) plainFooter() []string {
+	var lines []string
+	shown, _ := m.visibleToasts()
+	for _, toast := range shown {
+		lines = append(lines, strings.ToUpper(toast.Type)+": "+toast.Message)
+	}
+
+	switch {
+	case m.showCommand:
+		lines = append(lines, "COMMAND> "+m.commandInput)
+	case m.isProcessing:
+		lines = append(lines, "PROCESSING...")
+	default:
+		input := []rune(m.input)
+		text := m.promptText() + string(input[:m.cursor]) + m.cursorGlyph() + string(input[m.cursor:])
+		for _, row := range wrapInput(text, safeWidth(m.width, 1)) {
+			lines = append(lines, string(row))
+		}
+	}
+	return lines
+}
+
+// plainMessageRows is how many message lines fit between the header and
+// the footer.
+func (m Model) plainMessageRows() int {
+	return safeWidth(m.height-1-len(m.plainFooter()), 0)
+}
+
+// plainModal renders a modal's text without its border and colors. Lists
+// mark the highlighted choice with "> " since there is no highlight.
+func plainModal(modal Modal, width, height int, s *styles) []string {
+	switch modal := modal.(type) {
+	case listModal:
+		lines := []string{modal.title}
+		for i, choice := range modal.choices {
+			mark := "  "
+			if i == modal.cursor {
+				mark = "> "
+			}
+			lines = append(lines, mark+choice)
+		}
+		return append(lines, "ENTER SELECTS, ESC CLOSES")
+	case grepModal:
+		lines := []string{fmt.Sprintf("GREP %q: %d MATCH(ES)", modal.pattern, len(modal.matches))}
+		for i, match := range modal.matches {
+			mark := "  "
+			if i == modal.cursor {
+				mark = "> "
+			}
+			lines = append(lines, mark+match.SessionID+"  "+match.Line)
+		}
+		return append(lines, "ENTER OPENS THE SESSION, ESC CLOSES")
+	}
+
+	var lines []string
+	for _, line := range strings.Split(plainText(modal.View(width, height, s)), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" && (len(lines) == 0 || lines[len(lines)-1] == "") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// plainText strips escape sequences and box-drawing characters from s.
+func plainText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' {
+			i += escapeLen(s[i:])
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r < 0x2500 || r > 0x257f {
+			b.WriteRune(r)
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// ============================================================================
+// Background Updates
+// ============================================================================
+
+// The Model is a value owned by the Bubble Tea event loop: only Update
+// changes it, and View and commands work on copies. Goroutines must never
+// mutate model state such as toasts, messages or mcpOps themselves. They
+// report through messages instead, either returned from a tea.Cmd or, for
+// long-lived producers, posted to the update channel, which pumpUpdates
+// feeds into the program with Program.Send. Either way Update applies the
+// change on the event loop, so no locking is needed.
+
+// updateBuffer is how many posted messages may wait for the event loop.
+const updateBuffer = 64
+
+// msgSender delivers messages to the event loop; *tea.Program is one.
+type msgSender interface {
+	Send(msg tea.Msg)
+}
+
+// pumpUpdates sends every message posted to updates on to sender, until
+// updates is closed or ctx is cancelled.
+func pumpUpdates(ctx context.Context, sender msgSender, updates <-chan tea.Msg) {
+	for msg := range syncutil.OrDone(ctx, updates) {
+		sender.Send(msg)
+	}
+}
+
+// post queues msg on the model's updates channel for Update and may be
+// called from any goroutine. It blocks while the buffer is full and reports
+// false if ctx ends first.
+func post(ctx context.Context, updates chan<- tea.Msg, msg tea.Msg) bool {
+	select {
+	case updates <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ============================================================================
+// Auto-scroll
+// ============================================================================
+
+// defaultScrollDelay is how long auto-scroll waits for appends to settle.
+const defaultScrollDelay = 150 * time.Millisecond
+
+// autoScroller debounces scroll-to-bottom requests: a burst of appended
+// messages scrolls once, delay after the last of them. It is shared across
+// model copies.
+type autoScroller struct {
+	mu      sync.Mutex
+	delay   time.Duration
+	pending func() bool // cancels the scheduled scroll; nil if none
+	fire    chan struct{}
+
+	// afterFunc calls f after d and returns a function that cancels the
+	// call; tests swap in a fake clock
+	afterFunc func(d time.Duration, f func()) (stop func() bool)
+}
+
+// AutoScrollMsg asks for the messages pane to scroll to the bottom.
+type AutoScrollMsg struct{}
+
+func newAutoScroller(delay time.Duration) *autoScroller {
+	return &autoScroller{
+		delay: delay,
+		fire:  make(chan struct{}, 1),
+		afterFunc: func(d time.Duration, f func()) func() bool {
+			return time.AfterFunc(d, f).Stop
+		},
 	}
+}
 
-	title := " COMMAND INPUT "
+// SetDelay changes the debounce window; a delay of 0 scrolls on the next
+// wait without debouncing.
+func (a *autoScroller) SetDelay(delay time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.delay = delay
+}
 
-	// Input with cursor
-	input := m.input
-	if m.cursor < len(m.input) {
-		input = m.input[:m.cursor] + "▊" + m.input[m.cursor:]
-	} else {
-		input = m.input + "▊"
+// Request schedules a scroll once no further request arrives within the
+// delay. A nil scroller does nothing.
+func (a *autoScroller) Request() {
+	if a == nil {
+		return
 	}
-
-	prompt := "> "
-	if m.isProcessing {
-		prompt = "◊ PROCESSING... "
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pending != nil {
+		a.pending()
 	}
+	a.pending = a.afterFunc(a.delay, a.scroll)
+}
 
-	inputLine := lipgloss.NewStyle().Foreground(crtAmber).Render(prompt + input)
-
-	// Help text
-	help := []string{
-		"",
-		"COMMANDS:",
-		"TAB      - Switch panes",
-		"CTRL+M   - Toggle MCP panel",
-		"CTRL+K   - Command palette",
-		"CTRL+G   - Glitch effect",
-		"CTRL+C   - Exit",
-		"",
-		"STATUS: " + strings.ToUpper(fmt.Sprintf("Ready")),
+// scroll wakes waitForAutoScroll.
+func (a *autoScroller) scroll() {
+	select {
+	case a.fire <- struct{}{}:
+	default: // a scroll is already pending
 	}
+}
 
-	if m.isProcessing {
-		help[len(help)-1] = "STATUS: PROCESSING..."
+// waitForAutoScroll delivers an AutoScrollMsg each time the debounced
+// scroll fires.
+func waitForAutoScroll(a *autoScroller) tea.Cmd {
+	return func() tea.Msg {
+		<-a.fire
+		return AutoScrollMsg{}
 	}
+}
 
-	helpText := lipgloss.NewStyle().Foreground(crtGreen).Render(strings.Join(help, "\n"))
+// ============================================================================
+// File Tailing
+// ============================================================================
 
-	content := lipgloss.JoinVertical(lipgloss.Left, inputLine, "", helpText)
+// tailPollInterval is how often a tailed file is checked for new lines.
+const tailPollInterval = 250 * time.Millisecond
 
-	return style.Render(lipgloss.JoinVertical(lipgloss.Left, title, content))
+// tailer follows one file in a background routine and hands each new line
+// to the program through lines.
+type tailer struct {
+	path    string
+	routine *syncutil.SafeRoutine
+	lines   chan string
 }
 
-func (m Model) renderMCP(width, height int) string {
-	style := mcpPanelStyle.Width(width - 2).Height(height - 2)
-	if m.activePane == "mcp" {
-		style = style.BorderForeground(crtAmber)
+// TailLineMsg is a line appended to the file followed by t.
+type TailLineMsg struct {
+	t    *tailer
+	line string
+}
+
+// TailStoppedMsg reports that the tail routine of t has ended.
+type TailStoppedMsg struct {
+	t   *tailer
+	err error
+}
+
+// startTail starts following path from its current end.
+func startTail(path string) (*tailer, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
 	}
 
-	title := " MCP OPS "
-	content := []string{}
+	t := &tailer{
+		path:    path,
+		routine: syncutil.NewSafeRoutine(context.Background()),
+		lines:   make(chan string),
+	}
+	t.routine.Run(func(ctx context.Context) error {
+		return tailFile(ctx, path, tailPollInterval, t.lines)
+	})
+	return t, nil
+}
 
-	for _, op := range m.mcpOps {
-		status := "◼"
-		if op.Status == "running" {
-			status = "◊"
-		} else if op.Status == "completed" {
-			status = "◆"
+// waitForTailLine delivers the next line of t, or TailStoppedMsg once its
+// routine ends.
+func waitForTailLine(t *tailer) tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case line := <-t.lines:
+			return TailLineMsg{t: t, line: line}
+		case <-t.routine.Done():
+			err := t.routine.Wait()
+			if errors.Is(err, context.Canceled) {
+				err = nil
+			}
+			return TailStoppedMsg{t: t, err: err}
 		}
+	}
+}
 
-		progress := ""
-		if op.Status == "running" {
-			filled := op.Progress / 10
-			progress = "\n[" + strings.Repeat("█", filled) + strings.Repeat("░", 10-filled) + "]"
+// tailFile polls path every interval and sends each complete line appended
+// after the call to lines, until ctx is done. A file that is truncated or
+// replaced (log rotation) is reread from the start; while path is missing
+// polling just continues.
+func tailFile(ctx context.Context, path string, interval time.Duration, lines chan<- string) error {
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
 		}
+	}()
 
-		opText := fmt.Sprintf("%s %s\n%s%s", status, op.ID, op.Tool, progress)
+	open := func(fromEnd bool) error {
+		next, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		if fromEnd {
+			if _, err := next.Seek(0, io.SeekEnd); err != nil {
+				next.Close()
+				return err
+			}
+		}
+		if f != nil {
+			f.Close()
+		}
+		f = next
+		return nil
+	}
+	if err := open(true); err != nil {
+		return err
+	}
 
-		color := crtPurple
-		if op.Status == "completed" {
-			color = crtGreen
-		} else if op.Status == "running" {
-			color = crtAmber
+	var partial []byte
+	buf := make([]byte, 32*1024)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := f.Read(buf)
+			partial = append(partial, buf[:n]...)
+			for {
+				i := bytes.IndexByte(partial, '\n')
+				if i < 0 {
+					break
+				}
+				line := strings.TrimRight(string(partial[:i]), "\r")
+				partial = partial[i+1:]
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			if n == 0 || err != nil {
+				break
+			}
 		}
 
-		content = append(content, lipgloss.NewStyle().Foreground(color).Render(opText))
-		content = append(content, "")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		// Detect rotation: a different file at path, or this one truncated
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		current, err := f.Stat()
+		if err != nil {
+			continue
+		}
+		offset, _ := f.Seek(0, io.SeekCurrent)
+		if !os.SameFile(info, current) || info.Size() < offset {
+			if open(false) == nil {
+				partial = nil
+			}
+		}
 	}
+}
 
-	inner := strings.Join(content, "\n")
-	return style.Render(lipgloss.JoinVertical(lipgloss.Left, title, inner))
+// stopTail stops the running tail, if any.
+func (m *Model) stopTail() {
+	if m.tail != nil {
+		m.tail.routine.Stop()
+		m.tail = nil
+	}
 }
 
-func (m Model) renderStatus() string {
-	left := fmt.Sprintf(" SESSION: %s | TOKENS: %d | COST: $%.2f ",
-		m.sessionID, m.contextTokens, m.cost)
+// ============================================================================
+// Main
+// ============================================================================
 
-	right := fmt.Sprintf(" %s | MEM: 64KB | CPU: 99%% ", time.Now().Format("15:04:05"))
+// Config holds the startup options set by command-line flags.
+type Config struct {
+	SessionPath string           // session file to resume
+	Theme       string           // built-in theme name
+	NoMCP       bool             // start with the MCP panel hidden
+	Mock        bool             // answer instantly with the canned provider
+	ToastPos    string           // where toasts are drawn, one of toastPositions
+	MaxWidth    int              // wrap width cap of message text; 0 for none
+	Once        string           // answer this prompt on stdout and exit
+	RecordPath  string           // log keys and resizes to this file
+	ReplayPath  string           // feed a recorded log back into the program
+	Demo        bool             // drive the UI through the scripted demo, then exit
+	Plain       bool             // screen-reader friendly layout without borders or effects
+	CPUProfile  string           // write a CPU profile of the run here
+	MemProfile  string           // write a heap profile here on exit
+	LogPath     string           // append structured JSON events to this file
+	Quiet       bool             // start with every effect turned off
+	NoBoot      bool             // skip the boot sequence
+	ScrollDelay time.Duration    // debounce before auto-scrolling to new messages
+	Spacing     int              // blank lines between messages
+	MaxToasts   int              // toasts shown before the rest collapse; 0 shows all
+	Prompt      string           // editor prompt template
+	Model       string           // name of the response provider
+	RateLimit   time.Duration    // minimum time between sends; 0 is no limit
+	IdleTimeout time.Duration    // show the screensaver after this long idle; 0 never does
+	Screensaver string           // screensaver style
+	Redact      []*regexp.Regexp // extra secret patterns masked in exports
+	Timeout     time.Duration    // how long a provider may take to answer; 0 waits forever
+	Retries     int              // extra attempts at a response that timed out or hit a network error
+	RetryDelay  time.Duration    // backoff before the first retry; it doubles after each
+	Greeting    string           // banner new sessions open with
+	System      string           // system prompt seeded into new sessions
+}
 
-	gap := m.width - lipgloss.Width(left) - lipgloss.Width(right)
-	if gap < 0 {
-		gap = 0
+// parseFlags parses the command-line arguments into a Config. -help prints
+// the usage to output and returns flag.ErrHelp.
+func parseFlags(args []string, output io.Writer) (Config, error) {
+	var cfg Config
+
+	fs := flag.NewFlagSet("retro-dgmo", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&cfg.SessionPath, "session", "", "resume the session saved at `path`")
+	fs.StringVar(&cfg.Theme, "theme", "classic", "color theme: classic, amber or phosphor")
+	fs.BoolVar(&cfg.NoMCP, "no-mcp", false, "start with the MCP panel hidden")
+	fs.BoolVar(&cfg.Mock, "mock", false, "answer instantly with the offline canned provider, without simulated latency")
+	fs.StringVar(&cfg.Model, "model", defaultModel, "response provider `name`: "+strings.Join(providerNames(), ", "))
+	fs.StringVar(&cfg.ToastPos, "toast-position", "top-center", "where toasts appear: "+strings.Join(toastPositions, ", "))
+	fs.IntVar(&cfg.MaxWidth, "max-width", defaultMaxContentWidth, "wrap message text at `n` columns; 0 uses the full pane")
+	fs.StringVar(&cfg.Once, "once", "", "print the response to `prompt` and exit without the TUI")
+	fs.StringVar(&cfg.RecordPath, "record", "", "record keys and resizes to `path`")
+	fs.StringVar(&cfg.ReplayPath, "replay", "", "replay the events recorded at `path`")
+	fs.BoolVar(&cfg.Demo, "demo", false, "play a scripted tour of the UI with the canned provider, then exit")
+	fs.StringVar(&cfg.CPUProfile, "cpuprofile", "", "write a CPU profile to `path`")
+	fs.StringVar(&cfg.MemProfile, "memprofile", "", "write a heap profile to `path` on exit")
+	fs.StringVar(&cfg.LogPath, "log", "", "append structured JSON logs to `path`")
+	fs.BoolVar(&cfg.Plain, "plain", false, "linear layout for screen readers: no borders, columns or effects")
+	fs.BoolVar(&cfg.Quiet, "quiet", false, "start with glitch, scanline, blink and bell effects and the boot sequence off")
+	fs.BoolVar(&cfg.NoBoot, "no-boot", false, "start without the boot sequence")
+	fs.StringVar(&cfg.Greeting, "greeting", "", "banner `text` new sessions open with")
+	fs.StringVar(&cfg.System, "system", "", "system prompt `text` seeded into new sessions")
+	fs.StringVar(&cfg.Prompt, "prompt", defaultPrompt, "editor prompt; {mode}, {model} and {session} expand")
+	fs.DurationVar(&cfg.Timeout, "timeout", defaultResponseTimeout, "give up on a response after `duration`; 0 waits forever")
+	fs.IntVar(&cfg.Retries, "retries", defaultRetryPolicy().MaxAttempts-1, "retry a response that timed out or hit a network error up to `n` times")
+	fs.DurationVar(&cfg.RetryDelay, "retry-delay", defaultRetryPolicy().InitialDelay, "wait `duration` before the first retry, doubling after each")
+	fs.Func("redact", "also mask matches of `regexp` in exports; may be repeated", func(pattern string) error {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		cfg.Redact = append(cfg.Redact, re)
+		return nil
+	})
+	fs.DurationVar(&cfg.RateLimit, "rate-limit", 0, "allow at most one message per `duration`; 0 disables the limit")
+	fs.DurationVar(&cfg.IdleTimeout, "idle", 0, "show the screensaver after `duration` without a keypress; 0 disables it")
+	fs.StringVar(&cfg.Screensaver, "screensaver", "logo", "screensaver style: "+strings.Join(screensaverStyles, " or "))
+	fs.DurationVar(&cfg.ScrollDelay, "scroll-delay", defaultScrollDelay, "wait this long for new messages to settle before auto-scrolling")
+	fs.IntVar(&cfg.MaxToasts, "max-toasts", defaultMaxToasts, "show at most `n` toasts and collapse the rest; 0 shows all")
+	fs.IntVar(&cfg.Spacing, "spacing", defaultSpacing, fmt.Sprintf("blank lines between messages, 0-%d", maxSpacing))
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
 	}
-
-	status := left + strings.Repeat("─", gap) + right
-
-	return statusBarStyle.Width(m.width).Render(status)
+	if fs.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if cfg.Demo && cfg.ReplayPath != "" {
+		return cfg, errors.New("-demo and -replay can't be combined")
+	}
+	return cfg, nil
 }
 
-func (m Model) renderCommandPalette(content string) string {
-	width := 60
-	height := 3
+// newProvider returns the name and response provider selected by cfg;
+// -mock and -demo override -model with the canned provider answering
+// instantly.
+func newProvider(cfg Config) (string, ResponseProvider, error) {
+	if cfg.Mock || cfg.Demo {
+		return defaultModel, cannedProvider{}, nil
+	}
+	name := strings.ToLower(cfg.Model)
+	if name == "" {
+		name = defaultModel
+	}
+	provider, ok := providers[name]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown model %q (have %s)", cfg.Model, strings.Join(providerNames(), ", "))
+	}
+	return name, provider, nil
+}
 
-	x := (m.width - width) / 2
-	y := (m.height - height) / 2
+// runOnce answers a single prompt with the provider within timeout and
+// writes the response to out. A provider that panics or runs out of time
+// is an error, so the process exits non-zero.
+func runOnce(ctx context.Context, provider ResponseProvider, prompt string, timeout time.Duration, out io.Writer) error {
+	resp, err := callWithin(ctx, timeout, func(ctx context.Context) (Response, error) {
+		return safeRespond(ctx, provider, prompt)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, resp.Content)
+	return err
+}
 
-	palette := lipgloss.NewStyle().
-		BorderStyle(lipgloss.DoubleBorder()).
-		BorderForeground(crtPink).
-		Background(darkBg).
-		Foreground(crtPink).
-		Width(width).
-		Height(height).
-		Padding(1).
-		Render("COMMAND> " + m.commandInput + "▊")
+// newModel builds the initial model configured by cfg.
+func newModel(cfg Config) (Model, error) {
+	m := initialModel()
 
-	lines := strings.Split(content, "\n")
-	for i := y; i < y+height+2 && i < len(lines); i++ {
-		if i >= 0 {
-			lines[i] = overlayString(lines[i], palette, x, i-y)
+	if cfg.Theme != "" {
+		if err := m.setTheme(cfg.Theme); err != nil {
+			return m, err
+		}
+	}
+	if cfg.NoMCP {
+		m.showMCP = false
+	}
+	if cfg.Quiet {
+		// Nothing is running yet, so Init decides which effects start
+		m.setQuiet(true)
+	}
+	if cfg.Plain {
+		m.plain = true
+		m.setQuiet(true)
+	}
+	// Replayed and demo keys would otherwise go to skipping the boot
+	m.booting = !cfg.NoBoot && !cfg.Quiet && !cfg.Plain && cfg.ReplayPath == "" && !cfg.Demo
+	m.prompt = cfg.Prompt
+	m.greeting, m.systemPrompt = cfg.Greeting, cfg.System
+	m.timeout = cfg.Timeout
+	if err := m.setScreensaver(cfg.Screensaver, cfg.IdleTimeout); err != nil {
+		return m, err
+	}
+	if m.plain {
+		m.idleTimeout = 0
+	}
+	m.redactPatterns = append(append([]*regexp.Regexp(nil), defaultRedactPatterns...), cfg.Redact...)
+	m.retry.MaxAttempts = cfg.Retries + 1
+	m.retry.InitialDelay = cfg.RetryDelay
+	if cfg.RateLimit > 0 {
+		m.sendLimit = syncutil.NewRateLimiter(cfg.RateLimit, 1)
+	}
+	m.messages = greetingMessages(m.clock(), m.greeting, m.systemPrompt)
+	if cfg.ScrollDelay != defaultScrollDelay {
+		m.autoScroll.SetDelay(cfg.ScrollDelay)
+	}
+	if err := m.setSpacing(cfg.Spacing); err != nil {
+		return m, err
+	}
+	if cfg.MaxToasts < 0 {
+		return m, errors.New("-max-toasts can't be negative")
+	}
+	m.toastConfig.MaxVisible = cfg.MaxToasts
+	name, provider, err := newProvider(cfg)
+	if err != nil {
+		return m, err
+	}
+	m.modelName, m.provider = name, provider
+	if cfg.ToastPos != "" {
+		if err := m.setToastPosition(cfg.ToastPos); err != nil {
+			return m, err
+		}
+	}
+	if err := m.setMaxContentWidth(cfg.MaxWidth); err != nil {
+		return m, err
+	}
+	if cfg.SessionPath != "" {
+		sf, err := loadSession(expandHome(cfg.SessionPath))
+		if err != nil {
+			return m, err
 		}
+		m.applySession(sf)
 	}
 
-	return strings.Join(lines, "\n")
+	return m, nil
 }
 
-func (m Model) renderToasts(content string) string {
-	y := 2
-	for _, toast := range m.toasts {
-		toastView := toastStyle.Render("◆ " + toast.Message + " ◆")
-		x := (m.width - lipgloss.Width(toastView)) / 2
+// recordedEvent is one line of a -record log: a key press or a resize,
+// stamped with its offset from the start of the recording.
+type recordedEvent struct {
+	AtMS   int64  `json:"at_ms"`
+	Kind   string `json:"kind"`          // "key" or "resize"
+	Key    int    `json:"key,omitempty"` // tea.KeyType
+	Runes  string `json:"runes,omitempty"`
+	Alt    bool   `json:"alt,omitempty"`
+	Paste  bool   `json:"paste,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
 
-		lines := strings.Split(content, "\n")
-		if y < len(lines) {
-			lines[y] = overlayString(lines[y], toastView, x, 0)
-		}
-		content = strings.Join(lines, "\n")
-		y += 2
+// eventFromMsg converts a message worth recording into an event.
+func eventFromMsg(msg tea.Msg, at time.Duration) (recordedEvent, bool) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		return recordedEvent{
+			AtMS:  at.Milliseconds(),
+			Kind:  "key",
+			Key:   int(msg.Type),
+			Runes: string(msg.Runes),
+			Alt:   msg.Alt,
+			Paste: msg.Paste,
+		}, true
+	case tea.WindowSizeMsg:
+		return recordedEvent{
+			AtMS:   at.Milliseconds(),
+			Kind:   "resize",
+			Width:  msg.Width,
+			Height: msg.Height,
+		}, true
 	}
-
-	return content
+	return recordedEvent{}, false
 }
 
-// ============================================================================
-// Helper Functions
-// ============================================================================
+// Msg converts the event back into the message it was recorded from.
+func (e recordedEvent) Msg() tea.Msg {
+	if e.Kind == "resize" {
+		return tea.WindowSizeMsg{Width: e.Width, Height: e.Height}
+	}
+	return tea.KeyMsg{
+		Type:  tea.KeyType(e.Key),
+		Runes: []rune(e.Runes),
+		Alt:   e.Alt,
+		Paste: e.Paste,
+	}
+}
 
-func (m *Model) addToast(message, toastType string) {
-	m.toasts = append(m.toasts, Toast{
-		Message:   message,
-		Type:      toastType,
-		ExpiresAt: time.Now().Add(3 * time.Second),
-	})
+// recordFilter returns a program filter that appends every key and resize
+// to w as a JSON line before passing the message on.
+func recordFilter(w io.Writer, start time.Time) func(tea.Model, tea.Msg) tea.Msg {
+	enc := json.NewEncoder(w)
+	return func(_ tea.Model, msg tea.Msg) tea.Msg {
+		if event, ok := eventFromMsg(msg, time.Since(start)); ok {
+			// A failed write only loses the log, never the session
+			_ = enc.Encode(event)
+		}
+		return msg
+	}
 }
 
-func (m *Model) executeCommand() {
-	cmd := strings.ToLower(m.commandInput)
+// readEvents parses a -record log.
+func readEvents(r io.Reader) ([]recordedEvent, error) {
+	var events []recordedEvent
+	dec := json.NewDecoder(r)
+	for {
+		var event recordedEvent
+		if err := dec.Decode(&event); err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
 
-	switch {
-	case strings.HasPrefix(cmd, "theme"):
-		m.addToast("THEME CHANGED", "info")
-	case strings.HasPrefix(cmd, "clear"):
-		m.messages = m.messages[:2] // Keep system messages
-		m.addToast("MESSAGES CLEARED", "info")
-	case strings.HasPrefix(cmd, "stats"):
-		m.addToast(fmt.Sprintf("TOKENS: %d | COST: $%.2f", m.contextTokens, m.cost), "info")
-	default:
-		m.addToast("UNKNOWN COMMAND", "error")
+// replayEvents sends the events to the program, waiting between them to
+// reproduce the recorded cadence.
+func replayEvents(p *tea.Program, events []recordedEvent) {
+	var last int64
+	for _, event := range events {
+		time.Sleep(time.Duration(event.AtMS-last) * time.Millisecond)
+		last = event.AtMS
+		p.Send(event.Msg())
 	}
 }
 
-func (m Model) applyGlitch(content string) string {
-	lines := strings.Split(content, "\n")
+// Demo pacing: keys are typed at a readable speed and each step is held
+// long enough to see.
+const (
+	demoTypingDelay = 60 * time.Millisecond
+	demoPause       = 2 * time.Second
+)
 
-	// Random glitch lines
-	for i := 0; i < 3; i++ {
-		y := rand.Intn(len(lines))
-		if y < len(lines) {
-			runes := []rune(lines[y])
-			for j := 0; j < 5; j++ {
-				x := rand.Intn(len(runes))
-				if x < len(runes) {
-					runes[x] = []rune(glitchChars[rand.Intn(len(glitchChars))])[0]
-				}
-			}
-			lines[y] = string(runes)
-		}
-	}
+// demoScript builds the events of the -demo tour, which replays through
+// replayEvents like a recording.
+type demoScript struct {
+	events []recordedEvent
+	at     time.Duration
+}
 
-	return strings.Join(lines, "\n")
+// wait advances the script's clock by d.
+func (s *demoScript) wait(d time.Duration) {
+	s.at += d
 }
 
-func (m Model) applyScanline(content string) string {
-	lines := strings.Split(content, "\n")
+// key presses a single key.
+func (s *demoScript) key(k tea.KeyType) {
+	event, _ := eventFromMsg(tea.KeyMsg{Type: k}, s.at)
+	s.events = append(s.events, event)
+}
 
-	if m.scanlineY < len(lines) && m.scanlineY >= 0 {
-		// Dim the scanline
-		line := lines[m.scanlineY]
-		dimmed := lipgloss.NewStyle().Foreground(mediumGray).Render(line)
-		lines[m.scanlineY] = dimmed
+// typeText types text one character at a time.
+func (s *demoScript) typeText(text string) {
+	for _, r := range text {
+		s.wait(demoTypingDelay)
+		event, _ := eventFromMsg(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}}, s.at)
+		s.events = append(s.events, event)
 	}
+}
 
-	return strings.Join(lines, "\n")
+// send types a message into the editor and sends it.
+func (s *demoScript) send(text string) {
+	s.typeText(text)
+	s.wait(demoPause / 4)
+	s.key(tea.KeyEnter)
+	s.wait(demoPause)
 }
 
-func wordWrap(text string, width int) []string {
-	var lines []string
-	words := strings.Fields(text)
+// command runs a palette command.
+func (s *demoScript) command(line string) {
+	s.key(tea.KeyCtrlK)
+	s.typeText(line)
+	s.wait(demoPause / 4)
+	s.key(tea.KeyEnter)
+	s.wait(demoPause)
+}
 
-	var currentLine string
-	for _, word := range words {
-		if currentLine == "" {
-			currentLine = word
-		} else if len(currentLine)+1+len(word) <= width {
-			currentLine += " " + word
-		} else {
-			lines = append(lines, currentLine)
-			currentLine = word
+// demoEvents is the -demo tour: a conversation that fills the MCP panel,
+// theme switches with their toasts, the glitch effect and matrix rain. It
+// runs without the boot sequence and quits at the end.
+func demoEvents() []recordedEvent {
+	var s demoScript
+	s.wait(demoPause / 2)
+
+	s.send("Hello! What can you do?")
+	s.send("Analyze the code in main.go")
+	s.command("theme amber")
+	s.key(tea.KeyCtrlG)
+	s.wait(demoPause)
+	s.send("Search the web for CRT monitors")
+	s.command("theme phosphor")
+	s.command("matrix")
+	s.wait(demoPause)
+	s.command("matrix")
+	s.command("theme classic")
+	s.send("Calculate 6 * 7")
+	s.key(tea.KeyCtrlC)
+	return s.events
+}
+
+// startProfiling starts the profiles requested by cfg. The returned stop
+// function finishes the CPU profile and writes the heap profile; with no
+// profiles requested both are no-ops.
+func startProfiling(cfg Config) (func(), error) {
+	var cpuFile *os.File
+	if cfg.CPUProfile != "" {
+		f, err := os.Create(cfg.CPUProfile)
+		if err != nil {
+			return func() {}, err
 		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return func() {}, err
+		}
+		cpuFile = f
 	}
 
-	if currentLine != "" {
-		lines = append(lines, currentLine)
+	stop := func() {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			cpuFile.Close()
+		}
+		if cfg.MemProfile != "" {
+			f, err := os.Create(cfg.MemProfile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: memprofile: %v\n", err)
+				return
+			}
+			defer f.Close()
+			runtime.GC() // get up-to-date statistics
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: memprofile: %v\n", err)
+			}
+		}
 	}
+	return stop, nil
+}
 
-	return lines
+// stdinIsPiped reports whether stdin is a pipe or file rather than a TTY.
+func stdinIsPiped() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice == 0
 }
 
-func overlayString(base, overlay string, x, y int) string {
-	if y != 0 {
-		return base
+// readPipedInput reads all of r as the first message, trimming surrounding
+// whitespace. Empty input yields an empty string.
+func readPipedInput(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
 	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func main() {
+	rand.Seed(time.Now().UnixNano())
 
-	baseRunes := []rune(base)
-	overlayRunes := []rune(overlay)
+	cfg, err := parseFlags(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
 
-	for i, r := range overlayRunes {
-		pos := x + i
-		if pos >= 0 && pos < len(baseRunes) {
-			baseRunes[pos] = r
+	if cfg.Once != "" {
+		_, provider, err := newProvider(cfg)
+		if err == nil {
+			err = runOnce(context.Background(), provider, withSystemPrompt(cfg.System, cfg.Once), cfg.Timeout, os.Stdout)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
+		return
 	}
 
-	return string(baseRunes)
-}
+	m, err := newModel(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 
-func generateResponse(input, tool string) string {
-	responses := map[string][]string{
-		"file_reader": {
-			"Analyzing file structure... Found 42 components across 7 modules.",
-			"File scan complete. Detected TypeScript, Go, and configuration files.",
-			"Reading directory tree... 1,337 files processed.",
-		},
-		"code_analyzer": {
-			"Code analysis initiated. Detecting patterns and potential optimizations.",
-			"Found 3 performance bottlenecks and 7 style violations.",
-			"Analysis complete. Code quality score: 8.5/10.",
-		},
-		"web_search": {
-			"Searching the retro-net... Found 256 relevant results.",
-			"Web crawl complete. Top result confidence: 94.2%.",
-			"Search terminated. Data packets retrieved successfully.",
-		},
-		"calculator": {
-			"Computing... Result: 42. The answer to everything.",
-			"Calculation complete. Quantum probability: 0.9999.",
-			"Mathematical operation successful. Check MCP logs for details.",
-		},
+	var opts []tea.ProgramOption
+	if stdinIsPiped() {
+		piped, err := readPipedInput(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: reading stdin: %v\n", err)
+			os.Exit(1)
+		}
+		if piped != "" {
+			m.input = piped
+			m.cursor = m.inputLen()
+			m.sendOnStart = true
+		}
+		// stdin is exhausted, so read keys from the terminal instead
+		opts = append(opts, tea.WithInputTTY())
 	}
 
-	toolResponses := responses[tool]
-	if toolResponses == nil {
-		toolResponses = responses["file_reader"]
+	if cfg.RecordPath != "" {
+		f, err := os.Create(expandHome(cfg.RecordPath))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		opts = append(opts, tea.WithFilter(recordFilter(f, time.Now())))
 	}
 
-	return toolResponses[rand.Intn(len(toolResponses))]
-}
+	var events []recordedEvent
+	if cfg.Demo {
+		events = demoEvents()
+	}
+	if cfg.ReplayPath != "" {
+		f, err := os.Open(expandHome(cfg.ReplayPath))
+		if err == nil {
+			events, err = readEvents(f)
+			f.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: replay: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-// ============================================================================
-// Main
-// ============================================================================
+	if cfg.LogPath != "" {
+		f, err := os.OpenFile(expandHome(cfg.LogPath), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		m.logger = newEventLogger(f)
+		m.logger.Event("start", "session "+m.sessionID)
+	}
 
-func main() {
-	rand.Seed(time.Now().UnixNano())
+	stopProfiling, err := startProfiling(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: profiling: %v\n", err)
+		os.Exit(1)
+	}
 
-	p := tea.NewProgram(initialModel())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := tea.NewProgram(m, opts...)
+	go pumpUpdates(ctx, p, m.updates)
+	if events != nil {
+		go replayEvents(p, events)
+	}
 	if _, err := p.Run(); err != nil {
+		m.logger.Error("program", err)
 		fmt.Printf("Error: %v", err)
 	}
+	m.logger.Event("exit", "session "+m.sessionID)
+	stopProfiling()
 }
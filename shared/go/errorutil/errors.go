@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand"
 	"runtime"
 	"strings"
 	"time"
@@ -191,6 +192,12 @@ type RetryConfig struct {
 	MaxDelay     time.Duration
 	Multiplier   float64
 	ShouldRetry  func(error) bool
+	// Jitter randomizes each delay by up to this fraction either way, so
+	// clients that failed together don't retry together
+	Jitter float64
+	// OnRetry, if set, is called with the number of the attempt about to
+	// start and the error of the one before it
+	OnRetry func(attempt int, err error)
 }
 
 // DefaultRetryConfig returns a default retry configuration
@@ -232,9 +239,13 @@ func Retry(ctx context.Context, config RetryConfig, operation func() error) erro
 				break
 			}
 			
+			if config.OnRetry != nil {
+				config.OnRetry(attempt+2, err)
+			}
+			
 			// Wait before retry
 			select {
-			case <-time.After(delay):
+			case <-time.After(jitter(delay, config.Jitter)):
 				// Increase delay for next attempt
 				delay = time.Duration(float64(delay) * config.Multiplier)
 				if delay > config.MaxDelay {
@@ -253,6 +264,18 @@ func Retry(ctx context.Context, config RetryConfig, operation func() error) erro
 		fmt.Sprintf("operation failed after %d attempts", config.MaxAttempts))
 }
 
+// jitterSource returns the random values in [0, 1) that jitter uses
+var jitterSource = rand.Float64
+
+// jitter returns d moved randomly by up to fraction of itself either way
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 || d <= 0 {
+		return d
+	}
+	offset := (jitterSource()*2 - 1) * fraction * float64(d)
+	return d + time.Duration(offset)
+}
+
 // Must panics if err is not nil
 func Must(err error) {
 	if err != nil {
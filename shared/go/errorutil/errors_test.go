@@ -0,0 +1,121 @@
+package errorutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgmstt/shared/testutil"
+)
+
+// fastRetry retries network errors with delays short enough for tests
+func fastRetry(attempts int) RetryConfig {
+	config := DefaultRetryConfig()
+	config.MaxAttempts = attempts
+	config.InitialDelay = time.Millisecond
+	config.MaxDelay = 2 * time.Millisecond
+	return config
+}
+
+func TestRetryOnRetryAttempts(t *testing.T) {
+	var attempts []int
+	var errs []error
+	config := fastRetry(3)
+	config.OnRetry = func(attempt int, err error) {
+		attempts = append(attempts, attempt)
+		errs = append(errs, err)
+	}
+
+	calls := 0
+	err := Retry(context.Background(), config, func() error {
+		calls++
+		return NetworkError("down", "http://example.com", 503)
+	})
+
+	testutil.AssertError(t, err)
+	testutil.AssertEqual(t, calls, 3)
+	// Called before each retry with the attempt about to start, never after the last
+	testutil.AssertEqual(t, attempts, []int{2, 3})
+	for _, err := range errs {
+		testutil.AssertTrue(t, Is(err, ErrNetwork), "OnRetry got %v", err)
+	}
+}
+
+func TestRetryOnRetryStopsOnSuccess(t *testing.T) {
+	var attempts []int
+	config := fastRetry(5)
+	config.OnRetry = func(attempt int, err error) { attempts = append(attempts, attempt) }
+
+	calls := 0
+	err := Retry(context.Background(), config, func() error {
+		if calls++; calls < 2 {
+			return TimeoutError("call", time.Second)
+		}
+		return nil
+	})
+
+	testutil.AssertNoError(t, err)
+	testutil.AssertEqual(t, attempts, []int{2})
+}
+
+func TestRetryOnRetryNotCalledForPermanentErrors(t *testing.T) {
+	called := false
+	config := fastRetry(3)
+	config.OnRetry = func(int, error) { called = true }
+
+	permanent := errors.New("bad request")
+	err := Retry(context.Background(), config, func() error { return permanent })
+
+	testutil.AssertEqual(t, err, permanent)
+	testutil.AssertFalse(t, called)
+}
+
+func TestJitterBounds(t *testing.T) {
+	defer func(source func() float64) { jitterSource = source }(jitterSource)
+
+	const d = time.Second
+	tests := []struct {
+		name     string
+		random   float64
+		fraction float64
+		want     time.Duration
+	}{
+		{"lowest draw", 0, 0.2, 800 * time.Millisecond},
+		{"middle draw", 0.5, 0.2, d},
+		{"highest draw", 0.75, 0.2, 1100 * time.Millisecond},
+		{"no jitter", 0, 0, d},
+		{"negative fraction", 0, -1, d},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jitterSource = func() float64 { return tt.random }
+			testutil.AssertEqual(t, jitter(d, tt.fraction), tt.want)
+		})
+	}
+}
+
+func TestJitterStaysWithinFraction(t *testing.T) {
+	const d, fraction = time.Second, 0.2
+	low, high := time.Duration(float64(d)*(1-fraction)), time.Duration(float64(d)*(1+fraction))
+	for i := 0; i < 1000; i++ {
+		got := jitter(d, fraction)
+		testutil.AssertTrue(t, got >= low && got <= high, "jitter(%s, %v) = %s", d, fraction, got)
+	}
+}
+
+func TestRetryAppliesJitter(t *testing.T) {
+	defer func(source func() float64) { jitterSource = source }(jitterSource)
+	draws := 0
+	jitterSource = func() float64 {
+		draws++
+		return 0
+	}
+
+	config := fastRetry(3)
+	config.Jitter = 0.5
+	_ = Retry(context.Background(), config, func() error { return ErrNetwork })
+
+	// One draw per wait between attempts
+	testutil.AssertEqual(t, draws, 2)
+}
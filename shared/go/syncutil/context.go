@@ -220,6 +220,53 @@ func OrDone[T any](ctx context.Context, c <-chan T) <-chan T {
 	return valStream
 }
 
+// MergeChans fans in the values of every channel in cs into one channel.
+// The output closes once all inputs are closed or ctx is done
+func MergeChans[T any](ctx context.Context, cs ...<-chan T) <-chan T {
+	out := make(chan T)
+	var wg sync.WaitGroup
+	wg.Add(len(cs))
+
+	for _, c := range cs {
+		go func(c <-chan T) {
+			defer wg.Done()
+			for v := range OrDone(ctx, c) {
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(c)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// DrainChan reads values from c until it closes, ctx is done, or max values
+// have been read, and returns what it got. A max of 0 or less reads without
+// limit
+func DrainChan[T any](ctx context.Context, c <-chan T, max int) []T {
+	var got []T
+	for max <= 0 || len(got) < max {
+		select {
+		case <-ctx.Done():
+			return got
+		case v, ok := <-c:
+			if !ok {
+				return got
+			}
+			got = append(got, v)
+		}
+	}
+	return got
+}
+
 // DoWithTimeout executes a function with a timeout
 func DoWithTimeout(timeout time.Duration, fn func() error) error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
@@ -295,6 +342,104 @@ func Throttle(duration time.Duration, fn func()) func() {
 	}
 }
 
+// RateLimiter is a token bucket holding up to burst tokens, refilled at one
+// token per interval. It is safe for concurrent use
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+// NewRateLimiter creates a rate limiter that starts with a full bucket
+func NewRateLimiter(interval time.Duration, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{interval: interval, burst: float64(burst), tokens: float64(burst)}
+}
+
+// Allow takes a token if one is available now
+func (r *RateLimiter) Allow() bool {
+	return r.AllowAt(time.Now())
+}
+
+// AllowAt takes a token if one is available at now, which lets callers
+// drive the limiter from their own clock
+func (r *RateLimiter) AllowAt(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.last.IsZero() && r.interval > 0 {
+		if elapsed := now.Sub(r.last); elapsed > 0 {
+			r.tokens += float64(elapsed) / float64(r.interval)
+			if r.tokens > r.burst {
+				r.tokens = r.burst
+			}
+		}
+	} else if r.interval <= 0 {
+		r.tokens = r.burst
+	}
+	r.last = now
+
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// RingBuffer keeps the last capacity items pushed, evicting the oldest
+// once full. It is safe for concurrent use
+type RingBuffer[T any] struct {
+	mu    sync.Mutex
+	items []T
+	start int // index of the oldest item
+	count int
+}
+
+// NewRingBuffer creates a ring buffer holding up to capacity items
+func NewRingBuffer[T any](capacity int) *RingBuffer[T] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &RingBuffer[T]{items: make([]T, capacity)}
+}
+
+// Push adds an item, evicting the oldest if the buffer is full
+func (r *RingBuffer[T]) Push(item T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.count < len(r.items) {
+		r.items[(r.start+r.count)%len(r.items)] = item
+		r.count++
+		return
+	}
+	r.items[r.start] = item
+	r.start = (r.start + 1) % len(r.items)
+}
+
+// Items returns the buffered items, oldest first
+func (r *RingBuffer[T]) Items() []T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items := make([]T, r.count)
+	for i := range items {
+		items[i] = r.items[(r.start+i)%len(r.items)]
+	}
+	return items
+}
+
+// Len returns the number of buffered items
+func (r *RingBuffer[T]) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.count
+}
+
 // WaitGroup with context support
 type ContextWaitGroup struct {
 	wg  sync.WaitGroup
@@ -0,0 +1,239 @@
+package syncutil
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgmstt/shared/testutil"
+)
+
+// sendAll returns a channel that yields values and then closes
+func sendAll[T any](values ...T) <-chan T {
+	c := make(chan T)
+	go func() {
+		defer close(c)
+		for _, v := range values {
+			c <- v
+		}
+	}()
+	return c
+}
+
+// receive reads from c until it closes, failing the test if that takes too long
+func receive[T any](t *testing.T, c <-chan T) []T {
+	t.Helper()
+	var got []T
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case v, ok := <-c:
+			if !ok {
+				return got
+			}
+			got = append(got, v)
+		case <-timeout:
+			t.Fatalf("channel still open after receiving %v", got)
+		}
+	}
+}
+
+func TestMergeChansDeliversAllValues(t *testing.T) {
+	out := MergeChans(context.Background(), sendAll(1, 2, 3), sendAll(4, 5), sendAll[int]())
+
+	got := receive(t, out)
+	sort.Ints(got)
+	testutil.AssertEqual(t, got, []int{1, 2, 3, 4, 5})
+}
+
+func TestMergeChansKeepsOrderPerInput(t *testing.T) {
+	got := receive(t, MergeChans(context.Background(), sendAll("a1", "a2", "a3"), sendAll("b1", "b2")))
+
+	var a, b []string
+	for _, v := range got {
+		if v[0] == 'a' {
+			a = append(a, v)
+		} else {
+			b = append(b, v)
+		}
+	}
+	testutil.AssertEqual(t, a, []string{"a1", "a2", "a3"})
+	testutil.AssertEqual(t, b, []string{"b1", "b2"})
+}
+
+func TestMergeChansClosesAfterInputs(t *testing.T) {
+	c1, c2 := make(chan int), make(chan int)
+	out := MergeChans(context.Background(), c1, c2)
+
+	close(c1)
+	c2 <- 7
+	testutil.AssertEqual(t, <-out, 7)
+	select {
+	case v, ok := <-out:
+		t.Fatalf("output delivered %v (open %v) while an input is still open", v, ok)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(c2)
+	testutil.AssertEqual(t, len(receive(t, out)), 0)
+}
+
+func TestMergeChansWithNoInputsCloses(t *testing.T) {
+	testutil.AssertEqual(t, len(receive(t, MergeChans[int](context.Background()))), 0)
+}
+
+func TestMergeChansStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	never := make(chan int) // never sends or closes
+	out := MergeChans(ctx, never)
+
+	cancel()
+	testutil.AssertEqual(t, len(receive(t, out)), 0)
+}
+
+func TestDrainChan(t *testing.T) {
+	closed := make(chan int, 3)
+	closed <- 1
+	closed <- 2
+	close(closed)
+
+	buffered := make(chan int, 3)
+	buffered <- 1
+	buffered <- 2
+	buffered <- 3
+
+	tests := []struct {
+		name string
+		c    <-chan int
+		max  int
+		want []int
+	}{
+		{"closed channel", closed, 0, []int{1, 2}},
+		{"open channel up to max", buffered, 2, []int{1, 2}},
+		{"open channel, remaining value", buffered, 1, []int{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testutil.AssertEqual(t, DrainChan(context.Background(), tt.c, tt.max), tt.want)
+		})
+	}
+}
+
+func TestDrainChanEmptyChannelStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	got := DrainChan(ctx, make(chan int), 0)
+	testutil.AssertEqual(t, len(got), 0)
+	testutil.AssertEqual(t, ctx.Err(), context.DeadlineExceeded)
+}
+
+func TestDrainChanClosedEmptyChannel(t *testing.T) {
+	c := make(chan int)
+	close(c)
+	testutil.AssertEqual(t, len(DrainChan(context.Background(), c, 0)), 0)
+}
+
+func TestRateLimiterAllowAt(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	type step struct {
+		at   time.Duration
+		want bool
+	}
+	tests := []struct {
+		name     string
+		interval time.Duration
+		burst    int
+		steps    []step
+	}{
+		{
+			name:     "burst then empty",
+			interval: time.Second, burst: 3,
+			steps: []step{{0, true}, {0, true}, {0, true}, {0, false}},
+		},
+		{
+			name:     "refills one token per interval",
+			interval: time.Second, burst: 1,
+			steps: []step{{0, true}, {500 * time.Millisecond, false}, {time.Second, true}, {time.Second, false}},
+		},
+		{
+			name:     "refill clamps to burst",
+			interval: time.Second, burst: 2,
+			steps: []step{{0, true}, {0, true}, {time.Minute, true}, {time.Minute, true}, {time.Minute, false}},
+		},
+		{
+			name:     "burst below 1 clamps to 1",
+			interval: time.Second, burst: 0,
+			steps: []step{{0, true}, {0, false}},
+		},
+		{
+			name:     "clock going back refills nothing",
+			interval: time.Second, burst: 1,
+			steps: []step{{time.Minute, true}, {0, false}, {500 * time.Millisecond, false}, {time.Second, true}},
+		},
+		{
+			name:     "no interval never limits",
+			interval: 0, burst: 1,
+			steps: []step{{0, true}, {0, true}, {0, true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRateLimiter(tt.interval, tt.burst)
+			for i, s := range tt.steps {
+				testutil.AssertEqual(t, r.AllowAt(start.Add(s.at)), s.want, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestRingBuffer(t *testing.T) {
+	r := NewRingBuffer[int](3)
+	testutil.AssertEqual(t, r.Len(), 0)
+	testutil.AssertEqual(t, r.Items(), []int{})
+
+	r.Push(1)
+	r.Push(2)
+	testutil.AssertEqual(t, r.Items(), []int{1, 2})
+
+	// Full: each push evicts the oldest, and order stays oldest first
+	for i := 3; i <= 7; i++ {
+		r.Push(i)
+	}
+	testutil.AssertEqual(t, r.Len(), 3)
+	testutil.AssertEqual(t, r.Items(), []int{5, 6, 7})
+}
+
+func TestRingBufferItemsIsACopy(t *testing.T) {
+	r := NewRingBuffer[string](2)
+	r.Push("a")
+	items := r.Items()
+	items[0] = "changed"
+	testutil.AssertEqual(t, r.Items(), []string{"a"})
+}
+
+func TestRingBufferCapacityBelowOne(t *testing.T) {
+	r := NewRingBuffer[int](0)
+	r.Push(1)
+	r.Push(2)
+	testutil.AssertEqual(t, r.Items(), []int{2})
+}
+
+func TestRingBufferConcurrentPush(t *testing.T) {
+	r := NewRingBuffer[int](10)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				r.Push(i)
+				r.Items()
+			}
+		}()
+	}
+	wg.Wait()
+	testutil.AssertEqual(t, r.Len(), 10)
+}